@@ -67,6 +67,7 @@ const (
 	defaultSessionTTL = 24 * time.Hour
 	defaultSessionKey = "wsl"
 	defaultCookiePath = "/"
+	defaultSiteURL    = "https://wsl520.com"
 )
 
 func init() {
@@ -163,6 +164,11 @@ func GetListen() string {
 	return GetStringDefault("listen", defaultListen)
 }
 
+// GetSiteURL get the url of site(without the trailing slash)
+func GetSiteURL() string {
+	return strings.TrimSuffix(GetStringDefault("site.url", defaultSiteURL), "/")
+}
+
 // GetTrackKey get the track cookie key
 func GetTrackKey() string {
 	return GetStringDefault("track", defaultTrackKey)
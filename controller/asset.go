@@ -24,7 +24,6 @@ import (
 
 	"github.com/gobuffalo/packr/v2"
 	"github.com/vicanso/elton"
-	"github.com/vicanso/wsl/cs"
 	"github.com/vicanso/wsl/router"
 	"github.com/vicanso/wsl/service"
 
@@ -41,23 +40,20 @@ type (
 )
 
 var (
-	box                = packr.New("asset", "../web/build")
-	contentPlaceholder = []byte("{CONTENT}")
-	titlePlaceHolder   = []byte("{TITLE}")
+	box = packr.New("asset", "../web/build")
 )
 
 const (
-	indexFile          = "index.html"
-	bookDetailURL      = "/book/%d"
-	bookDetailTemplate = `<h3>%s</h3>
-		<p>%s</p>
-		<p>%s</p>
-		<ul>%s</ul>
-	`
-	bookChapterURL      = "/book/%d/chapter/%d"
-	bookChapterTemplate = `<h4>%s</h4>
-		<div>%s</div>
-	`
+	indexFile   = "index.html"
+	ssrSiteName = "卫斯理小说"
+
+	bookDetailURL  = "/book/%d"
+	bookChapterURL = "/book/%d/chapter/%d"
+	tagDetailURL   = "/tag/%s"
+	bookCoverURL   = "/books/v1/%d/cover"
+	bookOGImageURL = "/books/v1/%d/og-image"
+	// 目录每页的章节数
+	bookTOCPageSize = 100
 )
 
 func (sf *staticFile) Exists(file string) bool {
@@ -81,8 +77,10 @@ func init() {
 	g := router.NewGroup("")
 	ctrl := assetCtrl{}
 	g.GET("/", ctrl.index)
-	g.GET("/book/:bookID", ctrl.bookDetail)
-	g.GET("/book/:bookID/chapter/:bookChapterNO", ctrl.bookChapterDetail)
+	g.GET("/tag/:tagSlug", ctrl.tagDetail)
+	// 管理员可通过preview=true预览未发布的书籍与章节
+	g.GET("/book/:bookID", checkPreview, ctrl.bookDetail)
+	g.GET("/book/:bookID/chapter/:bookChapterNO", checkPreview, ctrl.bookChapterDetail)
 	g.GET("/admin", ctrl.index)
 	g.GET("/admin/*adminPath", ctrl.adminIndex)
 	g.GET("/favicon.ico", ctrl.favIcon)
@@ -120,117 +118,257 @@ func sendFile(c *elton.Context, file string) (err error) {
 	return
 }
 
+// formatLangURL format the url of page with the lang prefix
+func formatLangURL(c *elton.Context, format string, args ...interface{}) string {
+	url := fmt.Sprintf(format, args...)
+	if isTCLang(c) {
+		url = "/" + getLang(c) + url
+	}
+	return url
+}
+
+// formatPageURL format the url of page, the lang prefix and preview query are kept
+func formatPageURL(c *elton.Context, format string, args ...interface{}) string {
+	url := formatLangURL(c, format, args...)
+	if isPreview(c) {
+		if strings.Contains(url, "?") {
+			url += "&preview=true"
+		} else {
+			url += "?preview=true"
+		}
+	}
+	return url
+}
+
+// getBookCover get the cover of book, the generated cover is used if it is empty
+func getBookCover(c *elton.Context, book *service.Book) string {
+	if book.Cover != "" {
+		return book.Cover
+	}
+	return formatLangURL(c, bookCoverURL, book.ID)
+}
+
+// fillGeneratedCover fill the generated cover of books which has no cover
+func fillGeneratedCover(c *elton.Context, books ...*service.Book) {
+	for _, book := range books {
+		if book.Cover == "" && book.ID != 0 {
+			book.GeneratedCover = formatLangURL(c, bookCoverURL, book.ID)
+		}
+	}
+}
+
+// getBookListItems get the items of book list for render
+func getBookListItems(c *elton.Context, books []*service.Book) []*ssrBookItem {
+	items := make([]*ssrBookItem, len(books))
+	// 繁体版本不展示简介
+	trimSummary := isTCLang(c)
+	for index, item := range books {
+		summary := item.Summary
+		if trimSummary {
+			summary = ""
+		}
+		items[index] = &ssrBookItem{
+			URL:     formatLangURL(c, bookDetailURL, item.ID),
+			Name:    item.Name,
+			Summary: summary,
+		}
+	}
+	return items
+}
+
 func (ctrl assetCtrl) index(c *elton.Context) (err error) {
-	buf, err := getFileContetAndSetContentType(c, indexFile)
+	books, err := bookSrv.List(service.BookQueryParams{
+		Limit:  1000,
+		Fields: "id,name,summary",
+	})
+	if err != nil {
+		return
+	}
+	err = renderSSR(c, ssrHome, &ssrPage{
+		Title: ssrSiteName,
+		Path:  "/",
+		Data: map[string]interface{}{
+			"Books": getBookListItems(c, books),
+		},
+	})
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("10m")
+	return
+}
+
+func (ctrl assetCtrl) tagDetail(c *elton.Context) (err error) {
+	tag, err := tagSrv.GetBySlug(c.Param("tagSlug"))
 	if err != nil {
 		return
 	}
 	books, err := bookSrv.List(service.BookQueryParams{
 		Limit:  1000,
 		Fields: "id,name,summary",
+		Sort:   "-hot_score",
+		Tags:   []string{tag.Slug},
 	})
 	if err != nil {
 		return
 	}
-	arr := make([]string, len(books))
-	detailURL := bookDetailURL
-	trimSummary := false
-	if c.QueryParam("lang") == cs.LangTC {
-		detailURL = "/" + cs.LangTC + detailURL
-		trimSummary = true
-	}
-	for index, item := range books {
-		url := fmt.Sprintf(detailURL, item.ID)
-		if trimSummary {
-			item.Summary = ""
-		}
-		html := fmt.Sprintf(`<li><h3><a href="%s">%s</a></h3><p>%s</p></li>`, url, item.Name, item.Summary)
-		arr[index] = html
+	err = renderSSR(c, ssrTag, &ssrPage{
+		Title:       tag.Name + "-" + ssrSiteName,
+		Description: truncateText(tag.Description, ssrDescriptionMaxLength),
+		Path:        fmt.Sprintf(tagDetailURL, tag.Slug),
+		Data: map[string]interface{}{
+			"Tag":   tag,
+			"Books": getBookListItems(c, books),
+		},
+	})
+	if err != nil {
+		return
 	}
-	content := "<ul>" + strings.Join(arr, "") + "</ul>"
-	buf = bytes.Replace(buf, contentPlaceholder, []byte(content), 1)
-	buf = bytes.Replace(buf, titlePlaceHolder, []byte("卫斯理小说"), 1)
-
 	c.CacheMaxAge("10m")
-	c.BodyBuffer = bytes.NewBuffer(buf)
 	return
 }
 
 func (ctrl assetCtrl) bookDetail(c *elton.Context) (err error) {
-	buf, err := getFileContetAndSetContentType(c, indexFile)
-	if err != nil {
-		return
-	}
 	id, _ := strconv.Atoi(c.Param("bookID"))
-	book, err := bookSrv.GetByID(uint(id))
+	book, err := getViewableBook(c, uint(id))
 	if err != nil {
 		return
 	}
-	chapters, err := bookSrv.ListChapter(service.ChapterQueryParams{
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	query := service.ChapterQueryParams{
 		BookID: uint(id),
 		Fields: "title,no",
-		Limit:  100,
-	})
+		Offset: (page - 1) * bookTOCPageSize,
+		Limit:  bookTOCPageSize,
+
+		Unpublished: isPreview(c),
+	}
+	count, err := bookSrv.CountChapter(query)
 	if err != nil {
 		return
 	}
-	arr := make([]string, len(chapters))
-	chapterURL := bookChapterURL
-	if c.QueryParam("lang") == cs.LangTC {
-		chapterURL = "/" + cs.LangTC + chapterURL
+	chapters, err := bookSrv.ListChapter(query)
+	if err != nil {
+		return
 	}
+	chapterLinks := make([]*ssrLink, len(chapters))
 	for index, item := range chapters {
-		url := fmt.Sprintf(chapterURL, id, item.NO)
-		arr[index] = fmt.Sprintf(`<li><a href="%s">%s</a></li>`, url, item.Title)
+		chapterLinks[index] = &ssrLink{
+			URL:  formatPageURL(c, bookChapterURL, id, item.NO),
+			Text: item.Title,
+		}
+	}
+	// 目录分页
+	pageCount := (count + bookTOCPageSize - 1) / bookTOCPageSize
+	pageLinks := make([]*ssrLink, 0)
+	if page > 1 {
+		pageLinks = append(pageLinks, &ssrLink{
+			URL:  formatPageURL(c, bookDetailURL+"?page=%d", id, page-1),
+			Text: "上一页",
+			Rel:  "prev",
+		})
+	}
+	if page < pageCount {
+		pageLinks = append(pageLinks, &ssrLink{
+			URL:  formatPageURL(c, bookDetailURL+"?page=%d", id, page+1),
+			Text: "下一页",
+			Rel:  "next",
+		})
+	}
+	path := fmt.Sprintf(bookDetailURL, id)
+	if page > 1 {
+		path = fmt.Sprintf(bookDetailURL+"?page=%d", id, page)
 	}
 
-	html := fmt.Sprintf(bookDetailTemplate, book.Name, book.Author, book.Summary, strings.Join(arr, ""))
-	buf = bytes.Replace(buf, contentPlaceholder, []byte(html), 1)
-	buf = bytes.Replace(buf, titlePlaceHolder, []byte(book.Name+"-卫斯理小说"), 1)
-
+	err = renderSSR(c, ssrBook, &ssrPage{
+		Title:       book.Name + "-" + ssrSiteName,
+		Description: truncateText(book.Summary, ssrDescriptionMaxLength),
+		Path:        path,
+		Type:        "book",
+		Image:       formatLangURL(c, bookOGImageURL, book.ID),
+		JSONLD:      newBookJSONLD(c, book),
+		Data: map[string]interface{}{
+			"Book":     book,
+			"Chapters": chapterLinks,
+			"Pages":    pageLinks,
+		},
+	})
+	if err != nil {
+		return
+	}
 	c.CacheMaxAge("1m")
-	c.BodyBuffer = bytes.NewBuffer(buf)
 	return
 }
 
 func (ctrl assetCtrl) bookChapterDetail(c *elton.Context) (err error) {
-	buf, err := getFileContetAndSetContentType(c, indexFile)
+	id, _ := strconv.Atoi(c.Param("bookID"))
+	book, err := getViewableBook(c, uint(id))
 	if err != nil {
 		return
 	}
-	id, _ := strconv.Atoi(c.Param("bookID"))
-	book, err := bookSrv.GetByID(uint(id))
+	chapterNO, _ := strconv.Atoi(c.Param("bookChapterNO"))
+	// 章节按NO获取（有未发布章节时NO与位置不一致）
+	chapter, err := getViewableChapter(c, uint(id), uint(chapterNO))
 	if err != nil {
 		return
 	}
-	chapterNO, _ := strconv.Atoi(c.Param("bookChapterNO"))
-	chapters, err := bookSrv.ListChapter(service.ChapterQueryParams{
-		BookID: uint(id),
-		Offset: chapterNO,
-		Limit:  1,
-	})
+	// 上一章、目录与下一章
+	prev, next, err := bookSrv.GetAdjacentChapters(uint(id), chapter.NO, isPreview(c))
 	if err != nil {
 		return
 	}
-	if len(chapters) != 0 {
-		chapter := chapters[0]
-		contentList := make([]string, 0)
-		for _, content := range strings.Split(chapter.Content, "\n") {
-			contentList = append(contentList, "<p>"+content+"</p>")
-		}
-		html := fmt.Sprintf(bookChapterTemplate, chapter.Title, strings.Join(contentList, ""))
-		buf = bytes.Replace(buf, contentPlaceholder, []byte(html), 1)
-		buf = bytes.Replace(buf, titlePlaceHolder, []byte(chapter.Title+"-"+book.Name+"-卫斯理小说"), 1)
+	links := make([]*ssrLink, 0)
+	if prev != nil {
+		links = append(links, &ssrLink{
+			URL:  formatPageURL(c, bookChapterURL, id, prev.NO),
+			Text: "上一章：" + prev.Title,
+			Rel:  "prev",
+		})
+	}
+	links = append(links, &ssrLink{
+		URL:  formatPageURL(c, bookDetailURL, id),
+		Text: "目录",
+	})
+	if next != nil {
+		links = append(links, &ssrLink{
+			URL:  formatPageURL(c, bookChapterURL, id, next.NO),
+			Text: "下一章：" + next.Title,
+			Rel:  "next",
+		})
 	}
 
+	err = renderSSR(c, ssrChapter, &ssrPage{
+		Title:       chapter.Title + "-" + book.Name + "-" + ssrSiteName,
+		Description: truncateText(chapter.Content, ssrDescriptionMaxLength),
+		Path:        fmt.Sprintf(bookChapterURL, id, chapter.NO),
+		Type:        "article",
+		Image:       formatLangURL(c, bookOGImageURL, book.ID),
+		JSONLD:      newChapterJSONLD(c, book, chapter),
+		Data: map[string]interface{}{
+			"Chapter":    chapter,
+			"Paragraphs": strings.Split(chapter.Content, "\n"),
+			"Links":      links,
+		},
+	})
+	if err != nil {
+		return
+	}
 	c.CacheMaxAge("1m")
-	c.BodyBuffer = bytes.NewBuffer(buf)
 	return
 }
 
 func (ctrl assetCtrl) adminIndex(c *elton.Context) (err error) {
+	err = renderSSR(c, ssrAdmin, &ssrPage{
+		Title: ssrSiteName,
+	})
+	if err != nil {
+		return
+	}
 	c.CacheMaxAge("10s")
-	return sendFile(c, indexFile)
+	return
 }
 
 func (ctrl assetCtrl) favIcon(c *elton.Context) (err error) {
@@ -15,17 +15,31 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
 	"github.com/vicanso/wsl/config"
 	"github.com/vicanso/wsl/cs"
 	"github.com/vicanso/wsl/router"
 	"github.com/vicanso/wsl/service"
+	"github.com/vicanso/wsl/util"
 	"github.com/vicanso/wsl/validate"
 	"go.uber.org/zap"
 )
 
+const (
+	// 上传的书籍压缩包大小限制
+	maxBookArchiveUploadSize = 100 * 1024 * 1024
+)
+
 type (
 	bookCtrl struct{}
 
@@ -34,16 +48,86 @@ type (
 		Limit   string `json:"limit,omitempty" valid:"xLimit"`
 		Offset  string `json:"offset,omitempty" valid:"xOffset"`
 		Keyword string `json:"keyword,omitempty" valid:"xBookKeyword,optional"`
+		Tags    string `json:"tags,omitempty" valid:"xTagSlugs,optional"`
+		TagMode string `json:"tagMode,omitempty" valid:"xTagMode,optional"`
+	}
+	searchBookParams struct {
+		Keyword string `json:"keyword,omitempty" valid:"xBookSearchKeyword"`
+		Limit   string `json:"limit,omitempty" valid:"xLimit"`
+		Offset  string `json:"offset,omitempty" valid:"xOffset"`
+	}
+	suggestBookParams struct {
+		Q     string `json:"q,omitempty" valid:"xBookKeyword"`
+		Limit string `json:"limit,omitempty" valid:"xLimit,optional"`
 	}
 	listChapterParams struct {
 		Fields string `json:"fields,omitempty" valid:"xFields"`
 		Limit  string `json:"limit,omitempty" valid:"xLimit"`
 		Offset string `json:"offset,omitempty" valid:"xOffset"`
+		// 从此NO开始获取，用于章节较多时的分页
+		From int `json:"from,string,omitempty" valid:"xBookChapterNO,optional"`
+	}
+	syncBookParams struct {
+		DryRun string `json:"dryRun,omitempty" valid:"xBookSyncDryRun,optional"`
+	}
+	syncBookArchiveParams struct {
+		Name   string `json:"name,omitempty" valid:"xBookArchiveName"`
+		DryRun string `json:"dryRun,omitempty" valid:"xBookSyncDryRun,optional"`
+	}
+	listBookSyncJobParams struct {
+		Status int    `json:"status,string,omitempty" valid:"xBookSyncJobStatus,optional"`
+		Limit  string `json:"limit,omitempty" valid:"xLimit"`
+		Offset string `json:"offset,omitempty" valid:"xOffset"`
+	}
+	listBookReviewParams struct {
+		Limit  string `json:"limit,omitempty" valid:"xLimit"`
+		Offset string `json:"offset,omitempty" valid:"xOffset"`
+	}
+	upsertBookReviewParams struct {
+		Score   int    `json:"score,omitempty" valid:"xBookReviewScore"`
+		Content string `json:"content,omitempty" valid:"xBookReviewContent,optional"`
+	}
+	addChapterParams struct {
+		// 插入的位置，默认添加至最后
+		NO      *int   `json:"no,omitempty" valid:"-"`
+		Title   string `json:"title,omitempty" valid:"xChapterTitle"`
+		Content string `json:"content,omitempty" valid:"xChapterContent"`
+		Note    string `json:"note,omitempty" valid:"xChapterNote,optional"`
+		// 发布状态，默认为已发布
+		Status    int        `json:"status,omitempty" valid:"xPublishStatus,optional"`
+		PublishAt *time.Time `json:"publishAt,omitempty" valid:"-"`
+	}
+	updateChapterParams struct {
+		Title     string     `json:"title,omitempty" valid:"xChapterTitle,optional"`
+		Content   string     `json:"content,omitempty" valid:"xChapterContent,optional"`
+		Note      string     `json:"note,omitempty" valid:"xChapterNote,optional"`
+		Status    int        `json:"status,omitempty" valid:"xPublishStatus,optional"`
+		PublishAt *time.Time `json:"publishAt,omitempty" valid:"-"`
+	}
+	diffChapterRevisionParams struct {
+		From uint `json:"from,string,omitempty" valid:"xChapterRevisionID"`
+		// 为空则与当前章节比较
+		To uint `json:"to,string,omitempty" valid:"xChapterRevisionID,optional"`
+	}
+	rollbackChapterParams struct {
+		Note string `json:"note,omitempty" valid:"xChapterNote,optional"`
+	}
+	moveChapterParams struct {
+		To int `json:"to,omitempty" valid:"xBookChapterNO,optional"`
+	}
+	listChapterEditLogParams struct {
+		Limit  string `json:"limit,omitempty" valid:"xLimit"`
+		Offset string `json:"offset,omitempty" valid:"xOffset"`
 	}
 	updateBookParams struct {
-		Hot     int    `json:"hot,omitempty" valid:"xBookHot,optional"`
+		// 使用指针，允许设置为0（取消人工热度）
+		Hot     *int   `json:"hot" valid:"xBookHot,optional"`
 		Summary string `json:"summary,omitempty" valid:"xBookSummary,optional"`
 		Cover   string `json:"cover,omitempty" valid:"xBookCover,optional"`
+		TagIDs  []uint `json:"tagIDs,omitempty" valid:"xTagIDs,optional"`
+		// 发布状态，定时发布时需指定发布时间
+		Status    int        `json:"status,omitempty" valid:"xPublishStatus,optional"`
+		PublishAt *time.Time `json:"publishAt,omitempty" valid:"-"`
 	}
 )
 
@@ -51,32 +135,263 @@ func init() {
 	ctrl := bookCtrl{}
 	g := router.NewGroup("/books")
 
-	g.POST("/sync-wsl", ctrl.syncWsl)
+	g.POST(
+		"/sync-wsl",
+		newTracker(cs.ActionBookSync),
+		shouldBeAdmin,
+		ctrl.syncWsl,
+	)
+	// 上传书籍压缩包(zip或tar.gz)同步
+	g.POST(
+		"/sync-archive",
+		newTracker(cs.ActionBookSyncArchive),
+		shouldBeAdmin,
+		ctrl.syncArchive,
+	)
+	// 同步任务
+	g.GET(
+		"/sync-jobs/v1",
+		shouldBeAdmin,
+		ctrl.listSyncJob,
+	)
+	g.GET(
+		"/sync-jobs/v1/:jobID",
+		shouldBeAdmin,
+		ctrl.getSyncJob,
+	)
+	g.POST(
+		"/sync-jobs/v1/:jobID/cancel",
+		newTracker(cs.ActionBookSyncJobCancel),
+		shouldBeAdmin,
+		ctrl.cancelSyncJob,
+	)
 
-	g.GET("/v1", ctrl.list)
-	g.GET("/v1/:bookID", ctrl.detail)
+	// 管理员可通过preview=true预览未发布的书籍与章节
+	g.GET("/v1", checkPreview, ctrl.list)
+	// 因为与/v1/:bookID有冲突，因此路径调整为/search/v1
+	g.GET("/search/v1", ctrl.search)
+	g.GET("/suggest/v1", ctrl.suggest)
+	g.GET("/v1/:bookID", checkPreview, ctrl.detail)
 	g.PATCH(
 		"/v1/:bookID",
 		newTracker(cs.ActionBookUpdate),
 		shouldBeAdmin,
 		ctrl.update,
 	)
-	g.GET("/v1/:bookID/chapters", ctrl.listChapter)
+	g.GET("/v1/:bookID/chapters", checkPreview, ctrl.listChapter)
+	g.GET("/v1/:bookID/chapters/:bookChapterNO", checkPreview, ctrl.chapterDetail)
+	g.GET("/v1/:bookID/epub", ctrl.epub)
+	// 生成的封面与分享图片（书籍无封面时使用）
+	g.GET("/v1/:bookID/cover", ctrl.cover)
+	g.GET("/v1/:bookID/og-image", ctrl.ogImage)
+
+	// 章节编辑
+	g.POST(
+		"/v1/:bookID/chapters",
+		newTracker(cs.ActionChapterAdd),
+		shouldBeAdmin,
+		ctrl.addChapter,
+	)
+	g.PATCH(
+		"/v1/:bookID/chapters/:bookChapterNO",
+		newTracker(cs.ActionChapterUpdate),
+		shouldBeAdmin,
+		ctrl.updateChapter,
+	)
+	g.DELETE(
+		"/v1/:bookID/chapters/:bookChapterNO",
+		newTracker(cs.ActionChapterDelete),
+		shouldBeAdmin,
+		ctrl.deleteChapter,
+	)
+	// 调整章节顺序
+	g.POST(
+		"/v1/:bookID/chapters/:bookChapterNO/move",
+		newTracker(cs.ActionChapterMove),
+		shouldBeAdmin,
+		ctrl.moveChapter,
+	)
+	// 章节的修改历史
+	g.GET(
+		"/v1/:bookID/chapters/:bookChapterNO/revisions",
+		shouldBeAdmin,
+		ctrl.listChapterRevision,
+	)
+	g.GET(
+		"/v1/:bookID/chapters/:bookChapterNO/diff",
+		shouldBeAdmin,
+		ctrl.diffChapterRevision,
+	)
+	g.POST(
+		"/v1/:bookID/chapters/:bookChapterNO/revisions/:revisionID/rollback",
+		newTracker(cs.ActionChapterRollback),
+		shouldBeAdmin,
+		ctrl.rollbackChapter,
+	)
+	// 章节编辑记录
+	g.GET(
+		"/v1/:bookID/chapter-logs",
+		shouldBeAdmin,
+		ctrl.listChapterEditLog,
+	)
+
+	// 书籍评论列表
+	g.GET("/v1/:bookID/reviews", ctrl.listReview)
+	// 获取当前用户的书评
+	g.GET(
+		"/v1/:bookID/reviews/me",
+		shouldLogined,
+		ctrl.getMyReview,
+	)
+	// 添加或更新书评（每个账号每本书只有一条）
+	g.POST(
+		"/v1/:bookID/reviews/me",
+		newTracker(cs.ActionBookReview),
+		shouldLogined,
+		ctrl.upsertMyReview,
+	)
+
+}
 
+// addBookView add the view count of book, the request of bot is ignored
+func addBookView(c *elton.Context, id uint) {
+	if util.IsBot(c.GetRequestHeader("User-Agent")) {
+		return
+	}
+	err := bookSrv.AddView(id)
+	if err != nil {
+		logger.Error("add book view fail",
+			zap.Uint("id", id),
+			zap.Error(err),
+		)
+	}
 }
 
+// startSyncJob start a book sync job, or return the diff of books if dry run
+func (ctrl bookCtrl) startSyncJob(c *elton.Context, source, path string, dryRun bool, onDone func()) (err error) {
+	// dry run只返回章节的变化，不更新数据
+	if dryRun {
+		if onDone != nil {
+			defer onDone()
+		}
+		results, err := bookSrv.SyncFromFile(context.Background(), service.BookSyncParams{
+			Path:   path,
+			DryRun: true,
+		})
+		if err != nil {
+			return err
+		}
+		c.Body = &struct {
+			Books []*service.BookSyncResult `json:"books,omitempty"`
+		}{
+			results,
+		}
+		return nil
+	}
+	job, err := bookSyncJobSrv.Start(service.BookSyncJobParams{
+		Source:  source,
+		Account: getUserSession(c).GetAccount(),
+		Path:    path,
+		OnDone:  onDone,
+	})
+	if err != nil {
+		return
+	}
+	c.Created(job)
+	return
+}
+
+// syncWsl sync book from the file path of server
 func (ctrl bookCtrl) syncWsl(c *elton.Context) (err error) {
+	params := &syncBookParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
 	filePath := config.GetString("filePath")
-	go func() {
-		err := bookSrv.SyncFromFile(filePath)
+	return ctrl.startSyncJob(c, cs.BookSyncSourceFile, filePath, params.DryRun == "true", nil)
+}
+
+// syncArchive sync book from the uploaded archive
+func (ctrl bookCtrl) syncArchive(c *elton.Context) (err error) {
+	c.Request.Body = http.MaxBytesReader(c.Response, c.Request.Body, maxBookArchiveUploadSize)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		err = hes.NewWithErrorStatusCode(err, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	params := &syncBookArchiveParams{}
+	err = validate.Do(params, map[string]string{
+		"name":   header.Filename,
+		"dryRun": c.Request.FormValue("dryRun"),
+	})
+	if err != nil {
+		return
+	}
+	dir, path, err := service.ExtractBookArchive(header.Filename, file, header.Size)
+	if err != nil {
+		return
+	}
+	// 同步结束后删除解压的文件
+	return ctrl.startSyncJob(c, cs.BookSyncSourceArchive, path, params.DryRun == "true", func() {
+		os.RemoveAll(dir)
+	})
+}
+
+// listSyncJob list book sync job
+func (ctrl bookCtrl) listSyncJob(c *elton.Context) (err error) {
+	params := &listBookSyncJobParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(params.Limit)
+	offset, _ := strconv.Atoi(params.Offset)
+	query := service.BookSyncJobQueryParams{
+		Status: params.Status,
+		Limit:  limit,
+		Offset: offset,
+	}
+	jobs, err := bookSyncJobSrv.List(query)
+	if err != nil {
+		return
+	}
+	count := -1
+	if offset == 0 {
+		count, err = bookSyncJobSrv.Count(query)
 		if err != nil {
-			logger.Error("sync wsl fail",
-				zap.Error(err),
-			)
 			return
 		}
-		logger.Info("sync wsl done")
-	}()
+	}
+	c.Body = &struct {
+		Jobs  []*service.BookSyncJob `json:"jobs,omitempty"`
+		Count int                    `json:"count,omitempty"`
+	}{
+		jobs,
+		count,
+	}
+	return
+}
+
+// getSyncJob get book sync job
+func (ctrl bookCtrl) getSyncJob(c *elton.Context) (err error) {
+	id, _ := strconv.Atoi(c.Param("jobID"))
+	job, err := bookSyncJobSrv.GetByID(uint(id))
+	if err != nil {
+		return
+	}
+	c.Body = job
+	return
+}
+
+// cancelSyncJob cancel the running book sync job
+func (ctrl bookCtrl) cancelSyncJob(c *elton.Context) (err error) {
+	id, _ := strconv.Atoi(c.Param("jobID"))
+	err = bookSyncJobSrv.Cancel(uint(id))
+	if err != nil {
+		return
+	}
 	c.NoContent()
 	return
 }
@@ -95,25 +410,95 @@ func (ctrl bookCtrl) list(c *elton.Context) (err error) {
 		Offset:  offset,
 		Keyword: params.Keyword,
 		Sort:    params.Sort,
+		TagMode: params.TagMode,
+
+		Unpublished: isPreview(c),
+	}
+	if params.Tags != "" {
+		query.Tags = strings.Split(params.Tags, ",")
 	}
 	books, err := bookSrv.List(query)
 	if err != nil {
 		return
 	}
+	fillGeneratedCover(c, books...)
 	count := -1
+	var facets []*service.TagFacet
+	// 首页时返回总数与各标签的书籍数量
 	if offset == 0 {
 		count, err = bookSrv.Count(query)
 		if err != nil {
 			return
 		}
+		facets, err = bookSrv.TagFacets(query)
+		if err != nil {
+			return
+		}
 	}
 	c.CacheMaxAge("1m")
 	c.Body = &struct {
-		Books []*service.Book `json:"books,omitempty"`
-		Count int             `json:"count,omitempty"`
+		Books  []*service.Book     `json:"books,omitempty"`
+		Count  int                 `json:"count,omitempty"`
+		Facets []*service.TagFacet `json:"facets,omitempty"`
 	}{
 		books,
 		count,
+		facets,
+	}
+	return
+}
+
+// suggest suggest the books by name, pinyin or initials
+func (ctrl bookCtrl) suggest(c *elton.Context) (err error) {
+	params := &suggestBookParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(params.Limit)
+	books, err := bookSrv.Suggest(params.Q, limit)
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("1m")
+	c.Body = map[string]interface{}{
+		"books": books,
+	}
+	return
+}
+
+// search search chapter content
+func (ctrl bookCtrl) search(c *elton.Context) (err error) {
+	params := &searchBookParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(params.Limit)
+	offset, _ := strconv.Atoi(params.Offset)
+	query := service.BookSearchParams{
+		Keyword: params.Keyword,
+		Limit:   limit,
+		Offset:  offset,
+	}
+	result, err := bookSrv.Search(query)
+	if err != nil {
+		return
+	}
+	count := -1
+	if offset == 0 {
+		count, err = bookSrv.CountSearch(query)
+		if err != nil {
+			return
+		}
+	}
+	c.CacheMaxAge("1m")
+	c.Body = &struct {
+		Results []*service.BookSearchResult `json:"results,omitempty"`
+		Count   int                         `json:"count,omitempty"`
+	}{
+		result,
+		count,
 	}
 	return
 }
@@ -121,13 +506,20 @@ func (ctrl bookCtrl) list(c *elton.Context) (err error) {
 // detail get detail content
 func (ctrl bookCtrl) detail(c *elton.Context) (err error) {
 	bookID, _ := strconv.Atoi(c.Param("bookID"))
-	book, err := bookSrv.GetByID(uint(bookID))
+	book, err := getViewableBook(c, uint(bookID))
+	if err != nil {
+		return
+	}
+	book.Tags, err = tagSrv.ListByBook(book.ID)
 	if err != nil {
 		return
 	}
-	// 查询详情一次则hot增加1
-	bookSrv.IncHotByID(book.ID, 1)
+	// 查询详情一次则浏览次数增加1（预览不计算）
+	if !isPreview(c) {
+		addBookView(c, book.ID)
+	}
 
+	fillGeneratedCover(c, book)
 	c.CacheMaxAge("1m")
 	c.Body = book
 	return
@@ -141,6 +533,11 @@ func (ctrl bookCtrl) listChapter(c *elton.Context) (err error) {
 		return
 	}
 	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	// 未发布的书籍不可获取章节
+	_, err = getViewableBook(c, uint(bookID))
+	if err != nil {
+		return
+	}
 	limit, _ := strconv.Atoi(params.Limit)
 	offset, _ := strconv.Atoi(params.Offset)
 	query := service.ChapterQueryParams{
@@ -148,13 +545,18 @@ func (ctrl bookCtrl) listChapter(c *elton.Context) (err error) {
 		BookID: uint(bookID),
 		Offset: offset,
 		Limit:  limit,
+		FromNO: uint(params.From),
+
+		Unpublished: isPreview(c),
 	}
 	chapters, err := bookSrv.ListChapter(query)
 	if err != nil {
 		return
 	}
-	// 获取一次章节列表热度增加1
-	bookSrv.IncHotByID(uint(bookID), 1)
+	// 获取一次章节列表浏览次数增加1（预览不计算）
+	if !isPreview(c) {
+		addBookView(c, uint(bookID))
+	}
 
 	c.CacheMaxAge("1m")
 	c.Body = &struct {
@@ -165,21 +567,347 @@ func (ctrl bookCtrl) listChapter(c *elton.Context) (err error) {
 	return
 }
 
-func (ctrl bookCtrl) update(c *elton.Context) (err error) {
-	params := &updateBookParams{}
+// chapterDetail get the chapter with the previous and next chapter
+func (ctrl bookCtrl) chapterDetail(c *elton.Context) (err error) {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	no, _ := strconv.Atoi(c.Param("bookChapterNO"))
+	chapter, err := getViewableChapter(c, uint(bookID), uint(no))
+	if err != nil {
+		return
+	}
+	prev, next, err := bookSrv.GetAdjacentChapters(uint(bookID), uint(no), isPreview(c))
+	if err != nil {
+		return
+	}
+	if !isPreview(c) {
+		addBookView(c, uint(bookID))
+	}
+
+	c.CacheMaxAge("1m")
+	c.Body = &struct {
+		Chapter *service.Chapter `json:"chapter,omitempty"`
+		Prev    *service.Chapter `json:"prev,omitempty"`
+		Next    *service.Chapter `json:"next,omitempty"`
+	}{
+		chapter,
+		prev,
+		next,
+	}
+	return
+}
+
+// epub download the epub of book
+func (ctrl bookCtrl) epub(c *elton.Context) (err error) {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	lang := getLang(c)
+	buf, book, err := bookSrv.GetEpub(uint(bookID), lang)
+	if err != nil {
+		return
+	}
+	name, _ := service.ConvertLang(lang, book.Name)
+	c.CacheMaxAge("10m")
+	c.SetHeader(elton.HeaderContentType, "application/epub+zip")
+	c.SetHeader("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name+".epub"))
+	c.BodyBuffer = bytes.NewBuffer(buf)
+	return
+}
+
+// sendGeneratedCover send the generated image of book
+func sendGeneratedCover(c *elton.Context, kind string) (err error) {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	buf, _, err := bookSrv.GetGeneratedCover(uint(bookID), kind, getLang(c))
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("1h")
+	c.SetHeader(elton.HeaderContentType, "image/png")
+	c.BodyBuffer = bytes.NewBuffer(buf)
+	return
+}
+
+// cover get the generated cover of book
+func (ctrl bookCtrl) cover(c *elton.Context) (err error) {
+	return sendGeneratedCover(c, service.BookCoverKindCover)
+}
+
+// ogImage get the generated open graph share image of book
+func (ctrl bookCtrl) ogImage(c *elton.Context) (err error) {
+	return sendGeneratedCover(c, service.BookCoverKindOG)
+}
+
+// listReview list the reviews of book
+func (ctrl bookCtrl) listReview(c *elton.Context) (err error) {
+	params := &listBookReviewParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	limit, _ := strconv.Atoi(params.Limit)
+	offset, _ := strconv.Atoi(params.Offset)
+	query := service.BookReviewQueryParams{
+		BookID: uint(bookID),
+		Limit:  limit,
+		Offset: offset,
+	}
+	reviews, err := bookSrv.ListReview(query)
+	if err != nil {
+		return
+	}
+	count := -1
+	if offset == 0 {
+		count, err = bookSrv.CountReview(query)
+		if err != nil {
+			return
+		}
+	}
+	c.CacheMaxAge("1m")
+	c.Body = &struct {
+		Reviews []*service.BookReview `json:"reviews,omitempty"`
+		Count   int                   `json:"count,omitempty"`
+	}{
+		reviews,
+		count,
+	}
+	return
+}
+
+// getMyReview get the review of current account
+func (ctrl bookCtrl) getMyReview(c *elton.Context) (err error) {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	review, err := bookSrv.GetReview(uint(bookID), getUserSession(c).GetAccount())
+	if err != nil {
+		return
+	}
+	c.Body = review
+	return
+}
+
+// upsertMyReview add or update the review of current account
+func (ctrl bookCtrl) upsertMyReview(c *elton.Context) (err error) {
+	params := &upsertBookReviewParams{}
 	err = validate.Do(params, c.RequestBody)
 	if err != nil {
 		return
 	}
 	bookID, _ := strconv.Atoi(c.Param("bookID"))
-	err = bookSrv.UpdateByID(uint(bookID), service.Book{
-		Hot:     params.Hot,
-		Summary: params.Summary,
-		Cover:   params.Cover,
+	err = bookSrv.UpsertReview(&service.BookReview{
+		BookID:  uint(bookID),
+		Account: getUserSession(c).GetAccount(),
+		Score:   params.Score,
+		Content: params.Content,
 	})
 	if err != nil {
 		return
 	}
+	c.NoContent()
+	return
+}
+
+// getChapterEditParams get the book id, chapter no and account of chapter edit
+func getChapterEditParams(c *elton.Context) service.ChapterEditParams {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	no, _ := strconv.Atoi(c.Param("bookChapterNO"))
+	return service.ChapterEditParams{
+		Account: getUserSession(c).GetAccount(),
+		BookID:  uint(bookID),
+		NO:      uint(no),
+	}
+}
+
+// addChapter add chapter
+func (ctrl bookCtrl) addChapter(c *elton.Context) (err error) {
+	params := &addChapterParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	editParams := getChapterEditParams(c)
+	editParams.Title = params.Title
+	editParams.Content = params.Content
+	editParams.Note = params.Note
+	editParams.Status = params.Status
+	editParams.PublishAt = params.PublishAt
+	if params.NO == nil {
+		editParams.Append = true
+	} else {
+		editParams.NO = uint(*params.NO)
+	}
+	chapter, err := bookSrv.AddChapter(editParams)
+	if err != nil {
+		return
+	}
+	c.Created(chapter)
+	return
+}
+
+// updateChapter update the title or content of chapter
+func (ctrl bookCtrl) updateChapter(c *elton.Context) (err error) {
+	params := &updateChapterParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	editParams := getChapterEditParams(c)
+	editParams.Title = params.Title
+	editParams.Content = params.Content
+	editParams.Note = params.Note
+	editParams.Status = params.Status
+	editParams.PublishAt = params.PublishAt
+	err = bookSrv.UpdateChapter(editParams)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// deleteChapter delete chapter
+func (ctrl bookCtrl) deleteChapter(c *elton.Context) (err error) {
+	err = bookSrv.DeleteChapter(getChapterEditParams(c))
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// moveChapter move chapter to the other no
+func (ctrl bookCtrl) moveChapter(c *elton.Context) (err error) {
+	params := &moveChapterParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = bookSrv.MoveChapter(getChapterEditParams(c), uint(params.To))
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listChapterRevision list the revisions of chapter
+func (ctrl bookCtrl) listChapterRevision(c *elton.Context) (err error) {
+	params := getChapterEditParams(c)
+	revisions, err := bookSrv.ListChapterRevision(params.BookID, params.NO)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Revisions []*service.ChapterRevision `json:"revisions,omitempty"`
+	}{
+		revisions,
+	}
+	return
+}
+
+// diffChapterRevision get the diff of two revisions
+func (ctrl bookCtrl) diffChapterRevision(c *elton.Context) (err error) {
+	params := &diffChapterRevisionParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	editParams := getChapterEditParams(c)
+	diff, err := bookSrv.DiffChapterRevision(editParams.BookID, editParams.NO, params.From, params.To)
+	if err != nil {
+		return
+	}
+	c.Body = diff
+	return
+}
+
+// rollbackChapter rollback chapter to the revision
+func (ctrl bookCtrl) rollbackChapter(c *elton.Context) (err error) {
+	params := &rollbackChapterParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	revisionID, _ := strconv.Atoi(c.Param("revisionID"))
+	editParams := getChapterEditParams(c)
+	editParams.Note = params.Note
+	err = bookSrv.RollbackChapter(editParams, uint(revisionID))
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listChapterEditLog list the edit logs of chapters
+func (ctrl bookCtrl) listChapterEditLog(c *elton.Context) (err error) {
+	params := &listChapterEditLogParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	limit, _ := strconv.Atoi(params.Limit)
+	offset, _ := strconv.Atoi(params.Offset)
+	query := service.ChapterEditLogQueryParams{
+		BookID: uint(bookID),
+		Limit:  limit,
+		Offset: offset,
+	}
+	logs, err := bookSrv.ListChapterEditLog(query)
+	if err != nil {
+		return
+	}
+	count := -1
+	if offset == 0 {
+		count, err = bookSrv.CountChapterEditLog(query)
+		if err != nil {
+			return
+		}
+	}
+	c.Body = &struct {
+		Logs  []*service.ChapterEditLog `json:"logs,omitempty"`
+		Count int                       `json:"count,omitempty"`
+	}{
+		logs,
+		count,
+	}
+	return
+}
+
+func (ctrl bookCtrl) update(c *elton.Context) (err error) {
+	params := &updateBookParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	// 使用map更新，hot为0时也需要保存
+	data := make(map[string]interface{})
+	if params.Hot != nil {
+		data["hot"] = *params.Hot
+	}
+	if params.Summary != "" {
+		data["summary"] = params.Summary
+	}
+	if params.Cover != "" {
+		data["cover"] = params.Cover
+	}
+	if len(data) != 0 {
+		err = bookSrv.UpdateByID(uint(bookID), data)
+		if err != nil {
+			return
+		}
+	}
+	if params.TagIDs != nil {
+		err = tagSrv.SetBookTags(uint(bookID), params.TagIDs)
+		if err != nil {
+			return
+		}
+	}
+	if params.Status != 0 {
+		err = bookSrv.UpdatePublish(uint(bookID), params.Status, params.PublishAt)
+		if err != nil {
+			return
+		}
+	}
 
 	c.NoContent()
 	return
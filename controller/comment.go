@@ -0,0 +1,250 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/middleware"
+	"github.com/vicanso/wsl/router"
+	"github.com/vicanso/wsl/service"
+	"github.com/vicanso/wsl/validate"
+)
+
+type (
+	commentCtrl struct{}
+
+	listCommentParams struct {
+		BookID uint   `json:"bookID,string,omitempty" valid:"xBookID"`
+		NO     int    `json:"no,string,omitempty" valid:"xBookChapterNO,optional"`
+		Limit  string `json:"limit,omitempty" valid:"xLimit"`
+		Offset string `json:"offset,omitempty" valid:"xOffset"`
+	}
+	addCommentParams struct {
+		BookID   uint   `json:"bookID,omitempty" valid:"xBookID"`
+		NO       int    `json:"no,omitempty" valid:"xBookChapterNO,optional"`
+		ParentID uint   `json:"parentID,omitempty" valid:"xCommentID,optional"`
+		Content  string `json:"content,omitempty" valid:"xCommentContent"`
+	}
+	reportCommentParams struct {
+		Reason string `json:"reason,omitempty" valid:"xCommentReportReason,optional"`
+	}
+	listReviewCommentParams struct {
+		Status int    `json:"status,string,omitempty" valid:"xCommentStatus,optional"`
+		Limit  string `json:"limit,omitempty" valid:"xLimit"`
+		Offset string `json:"offset,omitempty" valid:"xOffset"`
+	}
+	reviewCommentParams struct {
+		Status int `json:"status,omitempty" valid:"xCommentStatus"`
+	}
+)
+
+func init() {
+	ctrl := commentCtrl{}
+	g := router.NewGroup("/comments", loadUserSession)
+
+	// 获取章节的评论（已审核通过）
+	g.GET("/v1", ctrl.list)
+	// 发表评论
+	g.POST(
+		"/v1",
+		newTracker(cs.ActionCommentAdd),
+		shouldLogined,
+		// 限制相同IP在60秒之内只能评论10次
+		newIPLimit(10, 60*time.Second, cs.ActionCommentAdd),
+		middleware.ValidateCaptch(),
+		ctrl.add,
+	)
+	// 举报评论
+	g.POST(
+		"/v1/:commentID/reports",
+		newTracker(cs.ActionCommentReport),
+		shouldLogined,
+		newIPLimit(10, 60*time.Second, cs.ActionCommentReport),
+		ctrl.report,
+	)
+
+	// 审核队列（因为与/v1/:commentID有冲突，因此路径调整为/reviews/v1）
+	g.GET(
+		"/reviews/v1",
+		shouldBeAdmin,
+		ctrl.listReview,
+	)
+	// 获取评论的举报记录
+	g.GET(
+		"/v1/:commentID/reports",
+		shouldBeAdmin,
+		ctrl.listReport,
+	)
+	// 审核评论
+	g.PATCH(
+		"/v1/:commentID",
+		newTracker(cs.ActionCommentReview),
+		shouldBeAdmin,
+		ctrl.review,
+	)
+}
+
+// list list the comments of chapter
+func (ctrl commentCtrl) list(c *elton.Context) (err error) {
+	params := &listCommentParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	offset, _ := strconv.Atoi(params.Offset)
+	limit, _ := strconv.Atoi(params.Limit)
+	query := service.CommentQueryParams{
+		BookID: params.BookID,
+		NO:     uint(params.NO),
+		Limit:  limit,
+		Offset: offset,
+	}
+	result, err := commentSrv.ListByChapter(query)
+	if err != nil {
+		return
+	}
+	count := -1
+	if offset == 0 {
+		count, err = commentSrv.CountByChapter(query)
+		if err != nil {
+			return
+		}
+	}
+	c.Body = &struct {
+		Comments []*service.Comment `json:"comments,omitempty"`
+		Count    int                `json:"count,omitempty"`
+	}{
+		result,
+		count,
+	}
+	return
+}
+
+// add add comment
+func (ctrl commentCtrl) add(c *elton.Context) (err error) {
+	params := &addCommentParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	comment := &service.Comment{
+		BookID:   params.BookID,
+		NO:       uint(params.NO),
+		ParentID: params.ParentID,
+		Account:  getUserSession(c).GetAccount(),
+		Content:  params.Content,
+	}
+	err = commentSrv.Add(comment)
+	if err != nil {
+		return
+	}
+	c.Created(comment)
+	return
+}
+
+// report report comment
+func (ctrl commentCtrl) report(c *elton.Context) (err error) {
+	params := &reportCommentParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("commentID"))
+	err = commentSrv.Report(&service.CommentReport{
+		CommentID: uint(id),
+		Account:   getUserSession(c).GetAccount(),
+		Reason:    params.Reason,
+	})
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listReview list the comments of review queue
+func (ctrl commentCtrl) listReview(c *elton.Context) (err error) {
+	params := &listReviewCommentParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	offset, _ := strconv.Atoi(params.Offset)
+	limit, _ := strconv.Atoi(params.Limit)
+	// 默认为待审核的评论
+	status := params.Status
+	if status == 0 {
+		status = cs.CommentPending
+	}
+	query := service.CommentQueryParams{
+		Status: status,
+		Limit:  limit,
+		Offset: offset,
+	}
+	result, err := commentSrv.ListReview(query)
+	if err != nil {
+		return
+	}
+	count := -1
+	if offset == 0 {
+		count, err = commentSrv.CountReview(query)
+		if err != nil {
+			return
+		}
+	}
+	c.Body = &struct {
+		Comments []*service.Comment `json:"comments,omitempty"`
+		Count    int                `json:"count,omitempty"`
+	}{
+		result,
+		count,
+	}
+	return
+}
+
+// listReport list the reports of comment
+func (ctrl commentCtrl) listReport(c *elton.Context) (err error) {
+	id, _ := strconv.Atoi(c.Param("commentID"))
+	result, err := commentSrv.ListReport(uint(id))
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Reports []*service.CommentReport `json:"reports,omitempty"`
+	}{
+		result,
+	}
+	return
+}
+
+// review update the status of comment
+func (ctrl commentCtrl) review(c *elton.Context) (err error) {
+	params := &reviewCommentParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("commentID"))
+	err = commentSrv.UpdateStatus(uint(id), params.Status)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
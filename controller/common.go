@@ -16,11 +16,9 @@ package controller
 
 import (
 	"bytes"
-	"fmt"
 	"strconv"
-	"strings"
 
-	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/config"
 	"github.com/vicanso/wsl/service"
 	"github.com/vicanso/wsl/util"
 
@@ -46,7 +44,6 @@ func init() {
 
 	g.GET("/captcha", ctrl.captcha)
 
-	g.GET("/sitemap.xml", ctrl.sitemap)
 	g.GET("/robots.txt", ctrl.robots)
 }
 
@@ -101,58 +98,10 @@ func (ctrl commonCtrl) captcha(c *elton.Context) (err error) {
 	return
 }
 
-func (ctrl commonCtrl) sitemap(c *elton.Context) (err error) {
-	template := `<?xml version="1.0" encoding="UTF-8"?>
-	<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"> 
-	  %s
-	</urlset>`
-	urlTemplate := `<url>
-		<loc>%s</loc>
-		<priority>%v</priority>
-		<changefreq>daily</changefreq>
-	</url>`
-	books, err := bookSrv.List(service.BookQueryParams{
-		Limit: 200,
-	})
-	if err != nil {
-		return
-	}
-	homeURL := "https://wsl520.com/"
-	tcHomeURL := homeURL + cs.LangTC + "/"
-	urls := make([]string, 0, 1000)
-	bookURL := homeURL + "book/%d"
-	tcBookURL := tcHomeURL + "book/%d"
-	bookPriority := 0.9
-	bookDetailURL := homeURL + "book/%d/chapter/%d"
-	tcBookDetailURL := tcHomeURL + "book/%d/chapter/%d"
-	bookDetailPriority := 0.63
-	urls = append(urls, fmt.Sprintf(urlTemplate, homeURL, 1.0))
-	urls = append(urls, fmt.Sprintf(urlTemplate, tcHomeURL, 1.0))
-
-	for _, book := range books {
-
-		url := fmt.Sprintf(bookURL, book.ID)
-		urls = append(urls, fmt.Sprintf(urlTemplate, url, bookPriority))
-		url = fmt.Sprintf(tcBookURL, book.ID)
-		urls = append(urls, fmt.Sprintf(urlTemplate, url, bookPriority))
-		for index := 0; index < book.ChapterCount; index++ {
-			url := fmt.Sprintf(bookDetailURL, book.ID, index)
-			urls = append(urls, fmt.Sprintf(urlTemplate, url, bookDetailPriority))
-
-			url = fmt.Sprintf(tcBookDetailURL, book.ID, index)
-			urls = append(urls, fmt.Sprintf(urlTemplate, url, bookDetailPriority))
-		}
-	}
-	c.CacheMaxAge("5m")
-	c.SetContentTypeByExt(".xml")
-	c.BodyBuffer = bytes.NewBufferString(fmt.Sprintf(template, strings.Join(urls, "")))
-	return
-}
-
 func (ctrl commonCtrl) robots(c *elton.Context) (err error) {
 	c.CacheMaxAge("5m")
 	c.BodyBuffer = bytes.NewBufferString(`
-Sitemap: https://wsl520.com/sitemap.xml
+Sitemap: ` + config.GetSiteURL() + `/sitemap.xml
 	`)
 	return
 }
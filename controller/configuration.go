@@ -42,6 +42,12 @@ type (
 		BeginDate *time.Time `json:"beginDate" valid:"-"`
 		EndDate   *time.Time `json:"endDate" valid:"-"`
 	}
+	previewS2TParams struct {
+		Text string `json:"text,omitempty" valid:"xConfigS2TText"`
+		Lang string `json:"lang,omitempty" valid:"xTraditionalLang,optional"`
+		// 未保存的词组覆盖配置，用于保存前预览效果
+		Data string `json:"data,omitempty" valid:"xConfigData,optional"`
+	}
 	listConfigurationParmas struct {
 		Name     string `json:"name,omitempty" valid:"xConfigName,optional"`
 		Category string `json:"category,omitempty" valid:"xConfigCategory,optional"`
@@ -75,6 +81,12 @@ func init() {
 		shouldBeAdmin,
 		ctrl.add,
 	)
+	// 预览简繁转换（包括词组覆盖）的效果
+	g.POST(
+		"/v1/s2t-preview",
+		shouldBeAdmin,
+		ctrl.previewS2T,
+	)
 	g.PATCH(
 		"/v1/:configID",
 		newTracker(cs.ActionConfigurationUpdate),
@@ -199,3 +211,28 @@ func (ctrl configurationCtrl) delete(c *elton.Context) (err error) {
 	c.NoContent()
 	return
 }
+
+// previewS2T preview the conversion of text
+func (ctrl configurationCtrl) previewS2T(c *elton.Context) (err error) {
+	params := &previewS2TParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	lang := params.Lang
+	if lang == "" {
+		lang = cs.LangTC
+	}
+	result, err := service.PreviewConvertLang(lang, params.Text, params.Data)
+	if err != nil {
+		return
+	}
+	// 预览结果已按指定语言转换，不需要再次转换
+	c.Set(cs.S2TDisabled, true)
+	c.Body = map[string]string{
+		"lang":   lang,
+		"text":   params.Text,
+		"result": result,
+	}
+	return
+}
@@ -51,6 +51,16 @@ var (
 	userSrv = new(service.UserSrv)
 	// 书籍服务
 	bookSrv = new(service.BookSrv)
+	// 书籍同步任务服务
+	bookSyncJobSrv = new(service.BookSyncJobSrv)
+	// 阅读服务
+	readingSrv = new(service.ReadingSrv)
+	// 书架服务
+	shelfSrv = new(service.ShelfSrv)
+	// 评论服务
+	commentSrv = new(service.CommentSrv)
+	// 标签服务
+	tagSrv = new(service.TagSrv)
 	// 服务列表 END
 
 	// 创建新的并发控制中间件
@@ -70,6 +80,8 @@ var (
 		cs.UserRoleSu,
 		cs.UserRoleAdmin,
 	}))
+	// 管理员预览未发布的内容
+	previewForAdmin = elton.Compose(shouldBeAdmin, setPreview)
 )
 
 func newTracker(action string) elton.Handler {
@@ -115,6 +127,50 @@ func checkAnonymous(c *elton.Context) (err error) {
 	return c.Next()
 }
 
+// checkPreview check the preview of unpublished content,
+// only admin can preview with query preview=true
+func checkPreview(c *elton.Context) (err error) {
+	if c.QueryParam("preview") != "true" {
+		return c.Next()
+	}
+	return previewForAdmin(c)
+}
+
+func setPreview(c *elton.Context) (err error) {
+	c.Set(cs.Preview, true)
+	err = c.Next()
+	// 预览的内容不可缓存（在处理函数之后设置，覆盖其设置的缓存）
+	c.NoCache()
+	return
+}
+
+// getLang get the lang of request(from url prefix or accept language)
+func getLang(c *elton.Context) string {
+	v, _ := c.Get(cs.Lang).(string)
+	return v
+}
+
+func isPreview(c *elton.Context) bool {
+	v, _ := c.Get(cs.Preview).(bool)
+	return v
+}
+
+// getViewableBook get the book, the unpublished one is only available for preview
+func getViewableBook(c *elton.Context, id uint) (*service.Book, error) {
+	if isPreview(c) {
+		return bookSrv.GetByID(id)
+	}
+	return bookSrv.GetPublishedByID(id)
+}
+
+// getViewableChapter get the chapter of book by no, the unpublished one is only available for preview
+func getViewableChapter(c *elton.Context, bookID, no uint) (*service.Chapter, error) {
+	if isPreview(c) {
+		return bookSrv.GetChapter(bookID, no)
+	}
+	return bookSrv.GetPublishedChapter(bookID, no)
+}
+
 func newCheckRoles(validRoles []string) elton.Handler {
 	return func(c *elton.Context) (err error) {
 		if !isLogin(c) {
@@ -0,0 +1,265 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/wsl/router"
+	"github.com/vicanso/wsl/service"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	atomXMLNS   = "http://www.w3.org/2005/Atom"
+	atomDCXMLNS = "http://purl.org/dc/terms/"
+	atomType    = "application/atom+xml"
+
+	feedURL     = "/feeds/v1/atom.xml"
+	feedBookURL = "/feeds/v1/books/%d/atom.xml"
+	// feed中的条目数量
+	feedEntryLimit = 30
+)
+
+type (
+	feedCtrl struct{}
+
+	atomFeed struct {
+		XMLName xml.Name     `xml:"feed"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		XMLNSDC string       `xml:"xmlns:dc,attr"`
+		ID      string       `xml:"id"`
+		Title   string       `xml:"title"`
+		Updated string       `xml:"updated"`
+		Author  *atomAuthor  `xml:"author,omitempty"`
+		Links   []*atomLink  `xml:"link"`
+		Entries []*atomEntry `xml:"entry"`
+	}
+	atomAuthor struct {
+		Name string `xml:"name"`
+	}
+	atomLink struct {
+		Rel   string `xml:"rel,attr,omitempty"`
+		Href  string `xml:"href,attr"`
+		Type  string `xml:"type,attr,omitempty"`
+		Title string `xml:"title,attr,omitempty"`
+	}
+	atomText struct {
+		Type string `xml:"type,attr,omitempty"`
+		Text string `xml:",chardata"`
+	}
+	atomEntry struct {
+		ID        string        `xml:"id"`
+		Title     string        `xml:"title"`
+		Published string        `xml:"published,omitempty"`
+		Updated   string        `xml:"updated"`
+		Authors   []*atomAuthor `xml:"author,omitempty"`
+		Language  string        `xml:"dc:language,omitempty"`
+		Summary   *atomText     `xml:"summary,omitempty"`
+		Content   *atomText     `xml:"content,omitempty"`
+		Links     []*atomLink   `xml:"link"`
+
+		// 用于排序与计算feed的更新时间
+		createdAt time.Time
+		updatedAt time.Time
+	}
+)
+
+func init() {
+	ctrl := feedCtrl{}
+	g := router.NewGroup("/feeds")
+
+	g.GET("/v1/atom.xml", ctrl.site)
+	g.GET("/v1/books/:bookID/atom.xml", ctrl.book)
+}
+
+func formatAtomTime(t time.Time) string {
+	if t.IsZero() {
+		t = util.Now()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newFeedBookEntry create the entry of new book
+func newFeedBookEntry(c *elton.Context, book *service.Book) *atomEntry {
+	link := getAbsoluteURL(formatLangURL(c, bookDetailURL, book.ID))
+	entry := &atomEntry{
+		ID:        link,
+		Title:     book.Name,
+		Published: formatAtomTime(book.CreatedAt),
+		Updated:   formatAtomTime(book.UpdatedAt),
+		Authors: []*atomAuthor{
+			{
+				Name: book.Author,
+			},
+		},
+		Language: getPageLang(c),
+		Links: []*atomLink{
+			{
+				Rel:  "alternate",
+				Href: link,
+				Type: "text/html",
+			},
+		},
+		createdAt: book.CreatedAt,
+		updatedAt: book.UpdatedAt,
+	}
+	if book.Summary != "" {
+		entry.Summary = &atomText{
+			Type: "text",
+			Text: book.Summary,
+		}
+	}
+	return entry
+}
+
+// newFeedChapterEntry create the entry of new chapter
+func newFeedChapterEntry(c *elton.Context, chapter *service.FeedChapter) *atomEntry {
+	link := getAbsoluteURL(formatLangURL(c, bookChapterURL, chapter.BookID, chapter.NO))
+	return &atomEntry{
+		ID:        link,
+		Title:     chapter.BookName + " " + chapter.Title,
+		Published: formatAtomTime(chapter.CreatedAt),
+		Updated:   formatAtomTime(chapter.UpdatedAt),
+		Language:  getPageLang(c),
+		Summary: &atomText{
+			Type: "text",
+			Text: truncateText(chapter.Summary, ssrDescriptionMaxLength),
+		},
+		Links: []*atomLink{
+			{
+				Rel:  "alternate",
+				Href: link,
+				Type: "text/html",
+			},
+		},
+		createdAt: chapter.CreatedAt,
+		updatedAt: chapter.UpdatedAt,
+	}
+}
+
+// setFeedBody sort the entries by created at and set the feed as response,
+// the last modified is set for conditional get
+func setFeedBody(c *elton.Context, feed *atomFeed) (err error) {
+	sort.SliceStable(feed.Entries, func(i, j int) bool {
+		return feed.Entries[i].createdAt.After(feed.Entries[j].createdAt)
+	})
+	if len(feed.Entries) > feedEntryLimit {
+		feed.Entries = feed.Entries[:feedEntryLimit]
+	}
+	var updatedAt time.Time
+	for _, entry := range feed.Entries {
+		if entry.updatedAt.After(updatedAt) {
+			updatedAt = entry.updatedAt
+		}
+	}
+	feed.Updated = formatAtomTime(updatedAt)
+	buf, err := xml.Marshal(feed)
+	if err != nil {
+		return
+	}
+	if !updatedAt.IsZero() {
+		c.SetHeader(elton.HeaderLastModified, updatedAt.UTC().Format(http.TimeFormat))
+	}
+	c.CacheMaxAge("5m")
+	c.SetHeader(elton.HeaderContentType, atomType+"; charset=utf-8")
+	c.BodyBuffer = bytes.NewBuffer(append([]byte(xml.Header), buf...))
+	return
+}
+
+func newAtomFeed(c *elton.Context, title, path string) *atomFeed {
+	selfURL := getAbsoluteURL(formatLangURL(c, path))
+	return &atomFeed{
+		XMLNS:   atomXMLNS,
+		XMLNSDC: atomDCXMLNS,
+		ID:      selfURL,
+		Title:   title,
+		Author: &atomAuthor{
+			Name: ssrSiteName,
+		},
+		Links: []*atomLink{
+			{
+				Rel:  "self",
+				Href: selfURL,
+				Type: atomType,
+			},
+		},
+	}
+}
+
+// site the feed of new books and chapters
+func (ctrl feedCtrl) site(c *elton.Context) (err error) {
+	books, err := bookSrv.List(service.BookQueryParams{
+		Sort:  "-created_at",
+		Limit: feedEntryLimit,
+	})
+	if err != nil {
+		return
+	}
+	chapters, err := bookSrv.ListNewChapters(0, feedEntryLimit)
+	if err != nil {
+		return
+	}
+	feed := newAtomFeed(c, ssrSiteName, feedURL)
+	feed.Links = append(feed.Links, &atomLink{
+		Rel:  "alternate",
+		Href: getAbsoluteURL(formatLangURL(c, "/")),
+		Type: "text/html",
+	})
+	for _, book := range books {
+		feed.Entries = append(feed.Entries, newFeedBookEntry(c, book))
+	}
+	for _, chapter := range chapters {
+		feed.Entries = append(feed.Entries, newFeedChapterEntry(c, chapter))
+	}
+	return setFeedBody(c, feed)
+}
+
+// book the feed of book's new chapters
+func (ctrl feedCtrl) book(c *elton.Context) (err error) {
+	id, _ := strconv.Atoi(c.Param("bookID"))
+	book, err := bookSrv.GetPublishedByID(uint(id))
+	if err != nil {
+		return
+	}
+	chapters, err := bookSrv.ListNewChapters(book.ID, feedEntryLimit)
+	if err != nil {
+		return
+	}
+	feed := newAtomFeed(c, book.Name+"-"+ssrSiteName, fmt.Sprintf(feedBookURL, book.ID))
+	feed.Links = append(feed.Links, &atomLink{
+		Rel:  "alternate",
+		Href: getAbsoluteURL(formatLangURL(c, bookDetailURL, book.ID)),
+		Type: "text/html",
+	})
+	for _, chapter := range chapters {
+		entry := newFeedChapterEntry(c, chapter)
+		entry.Title = chapter.Title
+		entry.Authors = []*atomAuthor{
+			{
+				Name: book.Author,
+			},
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return setFeedBody(c, feed)
+}
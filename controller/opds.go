@@ -0,0 +1,397 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/wsl/router"
+	"github.com/vicanso/wsl/service"
+	"github.com/vicanso/wsl/validate"
+)
+
+const (
+	opdsOpenSearchXMLNS = "http://a9.com/-/spec/opensearch/1.1/"
+
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsOpenSearchType  = "application/opensearchdescription+xml"
+
+	opdsRelAcquisition = "http://opds-spec.org/acquisition/open-access"
+	opdsRelImage       = "http://opds-spec.org/image"
+	opdsRelThumbnail   = "http://opds-spec.org/image/thumbnail"
+
+	opdsRootURL         = "/opds/v1"
+	opdsBooksURL        = "/opds/v1/books"
+	opdsBookURL         = "/opds/v1/books/%d"
+	opdsSearchURL       = "/opds/v1/search.xml"
+	opdsBookEpubURL     = "/books/v1/%d/epub"
+	opdsBookPageSize    = 20
+	opdsChapterPageSize = 100
+)
+
+type (
+	opdsCtrl struct{}
+
+	listOPDSBookParams struct {
+		Keyword string `json:"keyword,omitempty" valid:"xBookKeyword,optional"`
+		Tags    string `json:"tags,omitempty" valid:"xTagSlugs,optional"`
+		Sort    string `json:"sort,omitempty" valid:"xOPDSSort,optional"`
+		Page    int    `json:"page,string,omitempty" valid:"xPage,optional"`
+	}
+	opdsBookParams struct {
+		Page int `json:"page,string,omitempty" valid:"xPage,optional"`
+	}
+
+	opdsFeed struct {
+		XMLName         xml.Name     `xml:"feed"`
+		XMLNS           string       `xml:"xmlns,attr"`
+		XMLNSDC         string       `xml:"xmlns:dc,attr"`
+		XMLNSOpenSearch string       `xml:"xmlns:opensearch,attr"`
+		ID              string       `xml:"id"`
+		Title           string       `xml:"title"`
+		Updated         string       `xml:"updated"`
+		Author          *atomAuthor  `xml:"author,omitempty"`
+		TotalResults    int          `xml:"opensearch:totalResults,omitempty"`
+		ItemsPerPage    int          `xml:"opensearch:itemsPerPage,omitempty"`
+		Links           []*atomLink  `xml:"link"`
+		Entries         []*atomEntry `xml:"entry"`
+	}
+	opdsOpenSearch struct {
+		XMLName     xml.Name               `xml:"OpenSearchDescription"`
+		XMLNS       string                 `xml:"xmlns,attr"`
+		ShortName   string                 `xml:"ShortName"`
+		Description string                 `xml:"Description"`
+		URL         *opdsOpenSearchURLItem `xml:"Url"`
+	}
+	opdsOpenSearchURLItem struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	}
+)
+
+func init() {
+	ctrl := opdsCtrl{}
+	g := router.NewGroup("/opds")
+
+	g.GET("/v1", ctrl.root)
+	g.GET("/v1/search.xml", ctrl.openSearch)
+	g.GET("/v1/books", ctrl.listBook)
+	g.GET("/v1/books/:bookID", ctrl.book)
+}
+
+// newOPDSFeed create the feed with the common links(self, start and search)
+func newOPDSFeed(c *elton.Context, id, title, selfURL, selfType string) *opdsFeed {
+	return &opdsFeed{
+		XMLNS:           atomXMLNS,
+		XMLNSDC:         atomDCXMLNS,
+		XMLNSOpenSearch: opdsOpenSearchXMLNS,
+		ID:              id,
+		Title:           title,
+		Updated:         formatAtomTime(time.Time{}),
+		Author: &atomAuthor{
+			Name: ssrSiteName,
+		},
+		Links: []*atomLink{
+			{
+				Rel:  "self",
+				Href: selfURL,
+				Type: selfType,
+			},
+			{
+				Rel:  "start",
+				Href: formatLangURL(c, opdsRootURL),
+				Type: opdsNavigationType,
+			},
+			{
+				Rel:  "search",
+				Href: formatLangURL(c, opdsSearchURL),
+				Type: opdsOpenSearchType,
+			},
+		},
+	}
+}
+
+// newOPDSBookEntry create the acquisition entry of book
+func newOPDSBookEntry(c *elton.Context, book *service.Book) *atomEntry {
+	entry := &atomEntry{
+		ID:      fmt.Sprintf("urn:wsl:book:%d", book.ID),
+		Title:   book.Name,
+		Updated: formatAtomTime(book.UpdatedAt),
+		Authors: []*atomAuthor{
+			{
+				Name: book.Author,
+			},
+		},
+		Language: getPageLang(c),
+		Links: []*atomLink{
+			{
+				Rel:  opdsRelAcquisition,
+				Href: formatLangURL(c, opdsBookEpubURL, book.ID),
+				Type: "application/epub+zip",
+			},
+			{
+				Rel:   "subsection",
+				Href:  formatLangURL(c, opdsBookURL, book.ID),
+				Type:  opdsAcquisitionType,
+				Title: "目录",
+			},
+			{
+				Rel:  "alternate",
+				Href: formatLangURL(c, bookDetailURL, book.ID),
+				Type: "text/html",
+			},
+		},
+	}
+	if book.Summary != "" {
+		entry.Summary = &atomText{
+			Type: "text",
+			Text: book.Summary,
+		}
+	}
+	cover := getBookCover(c, book)
+	entry.Links = append(entry.Links, &atomLink{
+		Rel:  opdsRelImage,
+		Href: cover,
+	}, &atomLink{
+		Rel:  opdsRelThumbnail,
+		Href: cover,
+	})
+	return entry
+}
+
+// addOPDSPageLinks add the pagination links of feed
+func addOPDSPageLinks(c *elton.Context, feed *opdsFeed, path string, query url.Values, page, pageSize, count int, feedType string) {
+	feed.TotalResults = count
+	feed.ItemsPerPage = pageSize
+	pageCount := (count + pageSize - 1) / pageSize
+	getURL := func(p int) string {
+		query.Set("page", strconv.Itoa(p))
+		return formatLangURL(c, path) + "?" + query.Encode()
+	}
+	if pageCount > 1 {
+		feed.Links = append(feed.Links, &atomLink{
+			Rel:  "first",
+			Href: getURL(1),
+			Type: feedType,
+		}, &atomLink{
+			Rel:  "last",
+			Href: getURL(pageCount),
+			Type: feedType,
+		})
+	}
+	if page > 1 {
+		feed.Links = append(feed.Links, &atomLink{
+			Rel:  "previous",
+			Href: getURL(page - 1),
+			Type: feedType,
+		})
+	}
+	if page < pageCount {
+		feed.Links = append(feed.Links, &atomLink{
+			Rel:  "next",
+			Href: getURL(page + 1),
+			Type: feedType,
+		})
+	}
+}
+
+// setOPDSBody set the xml of opds as response
+func setOPDSBody(c *elton.Context, contentType string, v interface{}) (err error) {
+	buf, err := xml.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("5m")
+	c.SetHeader(elton.HeaderContentType, contentType)
+	c.BodyBuffer = bytes.NewBuffer(append([]byte(xml.Header), buf...))
+	return
+}
+
+// root the navigation feed of catalog
+func (ctrl opdsCtrl) root(c *elton.Context) (err error) {
+	feed := newOPDSFeed(c, "urn:wsl:root", ssrSiteName, formatLangURL(c, opdsRootURL), opdsNavigationType)
+	now := formatAtomTime(time.Time{})
+	newNavigationEntry := func(id, title, content string, query url.Values) *atomEntry {
+		href := formatLangURL(c, opdsBooksURL)
+		if len(query) != 0 {
+			href += "?" + query.Encode()
+		}
+		return &atomEntry{
+			ID:      id,
+			Title:   title,
+			Updated: now,
+			Content: &atomText{
+				Type: "text",
+				Text: content,
+			},
+			Links: []*atomLink{
+				{
+					Rel:  "subsection",
+					Href: href,
+					Type: opdsAcquisitionType,
+				},
+			},
+		}
+	}
+	feed.Entries = append(feed.Entries,
+		newNavigationEntry("urn:wsl:books:hot", "热门书籍", "按热度排序的书籍", url.Values{
+			"sort": []string{"-hot_score"},
+		}),
+		newNavigationEntry("urn:wsl:books:latest", "最近更新", "按更新时间排序的书籍", url.Values{
+			"sort": []string{"-updated_at"},
+		}),
+	)
+	tags, err := tagSrv.List()
+	if err != nil {
+		return
+	}
+	for _, tag := range tags {
+		if tag.BookCount == 0 {
+			continue
+		}
+		feed.Entries = append(feed.Entries, newNavigationEntry("urn:wsl:tag:"+tag.Slug, tag.Name, tag.Description, url.Values{
+			"tags": []string{tag.Slug},
+		}))
+	}
+	return setOPDSBody(c, opdsNavigationType, feed)
+}
+
+// openSearch the open search description of catalog
+func (ctrl opdsCtrl) openSearch(c *elton.Context) (err error) {
+	return setOPDSBody(c, opdsOpenSearchType, &opdsOpenSearch{
+		XMLNS:       opdsOpenSearchXMLNS,
+		ShortName:   ssrSiteName,
+		Description: "搜索书籍",
+		URL: &opdsOpenSearchURLItem{
+			Type:     opdsAcquisitionType,
+			Template: formatLangURL(c, opdsBooksURL) + "?keyword={searchTerms}",
+		},
+	})
+}
+
+// listBook the acquisition feed of books
+func (ctrl opdsCtrl) listBook(c *elton.Context) (err error) {
+	params := &listOPDSBookParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	page := params.Page
+	if page < 1 {
+		page = 1
+	}
+	query := service.BookQueryParams{
+		Keyword: params.Keyword,
+		Sort:    params.Sort,
+		Offset:  (page - 1) * opdsBookPageSize,
+		Limit:   opdsBookPageSize,
+	}
+	if query.Sort == "" {
+		query.Sort = "-hot_score"
+	}
+	if params.Tags != "" {
+		query.Tags = strings.Split(params.Tags, ",")
+	}
+	books, err := bookSrv.List(query)
+	if err != nil {
+		return
+	}
+	count, err := bookSrv.Count(query)
+	if err != nil {
+		return
+	}
+	values := url.Values{}
+	if params.Keyword != "" {
+		values.Set("keyword", params.Keyword)
+	}
+	if params.Tags != "" {
+		values.Set("tags", params.Tags)
+	}
+	if params.Sort != "" {
+		values.Set("sort", params.Sort)
+	}
+	selfURL := formatLangURL(c, opdsBooksURL)
+	if len(values) != 0 {
+		selfURL += "?" + values.Encode()
+	}
+	feed := newOPDSFeed(c, "urn:wsl:books?"+values.Encode(), ssrSiteName, selfURL, opdsAcquisitionType)
+	for _, book := range books {
+		feed.Entries = append(feed.Entries, newOPDSBookEntry(c, book))
+	}
+	addOPDSPageLinks(c, feed, opdsBooksURL, values, page, opdsBookPageSize, count, opdsAcquisitionType)
+	return setOPDSBody(c, opdsAcquisitionType, feed)
+}
+
+// book the acquisition feed of book, the chapters are listed as entries
+func (ctrl opdsCtrl) book(c *elton.Context) (err error) {
+	params := &opdsBookParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	page := params.Page
+	if page < 1 {
+		page = 1
+	}
+	id, _ := strconv.Atoi(c.Param("bookID"))
+	book, err := bookSrv.GetPublishedByID(uint(id))
+	if err != nil {
+		return
+	}
+	query := service.ChapterQueryParams{
+		BookID: book.ID,
+		Fields: "no, title, updated_at",
+		Offset: (page - 1) * opdsChapterPageSize,
+		Limit:  opdsChapterPageSize,
+	}
+	chapters, err := bookSrv.ListChapter(query)
+	if err != nil {
+		return
+	}
+	count, err := bookSrv.CountChapter(query)
+	if err != nil {
+		return
+	}
+	feed := newOPDSFeed(c, fmt.Sprintf("urn:wsl:book:%d:chapters", book.ID), book.Name, formatLangURL(c, opdsBookURL, book.ID), opdsAcquisitionType)
+	feed.Updated = formatAtomTime(book.UpdatedAt)
+	// 第一个条目为整本书籍
+	feed.Entries = append(feed.Entries, newOPDSBookEntry(c, book))
+	// 章节为阅读页面而非可下载的书籍，因此使用alternate（仅书籍的epub为acquisition）
+	for _, chapter := range chapters {
+		feed.Entries = append(feed.Entries, &atomEntry{
+			ID:       fmt.Sprintf("urn:wsl:book:%d:chapter:%d", book.ID, chapter.NO),
+			Title:    chapter.Title,
+			Updated:  formatAtomTime(chapter.UpdatedAt),
+			Language: getPageLang(c),
+			Links: []*atomLink{
+				{
+					Rel:  "alternate",
+					Href: formatLangURL(c, bookChapterURL, book.ID, chapter.NO),
+					Type: "text/html",
+				},
+			},
+		})
+	}
+	addOPDSPageLinks(c, feed, fmt.Sprintf(opdsBookURL, book.ID), url.Values{}, page, opdsChapterPageSize, count, opdsAcquisitionType)
+	return setOPDSBody(c, opdsAcquisitionType, feed)
+}
@@ -0,0 +1,281 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/router"
+)
+
+const (
+	sitemapXMLNS      = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapXHTMLXMLNS = "http://www.w3.org/1999/xhtml"
+	// 每个章节sitemap的章节数（每个章节包括简体与各繁体共4个url，需要少于50000）
+	sitemapChapterLimit = 10000
+
+	sitemapPagesName   = "pages.xml"
+	sitemapChapterName = "chapters-%d.xml"
+)
+
+type (
+	sitemapCtrl struct{}
+
+	sitemapIndex struct {
+		XMLName  xml.Name       `xml:"sitemapindex"`
+		XMLNS    string         `xml:"xmlns,attr"`
+		Sitemaps []*sitemapItem `xml:"sitemap"`
+	}
+	sitemapItem struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod,omitempty"`
+	}
+	sitemapURLSet struct {
+		XMLName xml.Name      `xml:"urlset"`
+		XMLNS   string        `xml:"xmlns,attr"`
+		XHTML   string        `xml:"xmlns:xhtml,attr"`
+		URLs    []*sitemapURL `xml:"url"`
+	}
+	sitemapURL struct {
+		Loc        string              `xml:"loc"`
+		LastMod    string              `xml:"lastmod,omitempty"`
+		Alternates []*sitemapAlternate `xml:"xhtml:link"`
+	}
+	sitemapAlternate struct {
+		Rel      string `xml:"rel,attr"`
+		HrefLang string `xml:"hreflang,attr"`
+		Href     string `xml:"href,attr"`
+	}
+	// sitemapCacheItem the cache of sitemap, it is valid until the version is changed
+	sitemapCacheItem struct {
+		version string
+		buf     []byte
+	}
+)
+
+var (
+	errSitemapNotFound = &hes.Error{
+		StatusCode: http.StatusNotFound,
+		Message:    "sitemap not found",
+	}
+
+	sitemapCache      = make(map[string]*sitemapCacheItem)
+	sitemapCacheMutex = new(sync.RWMutex)
+)
+
+func init() {
+	ctrl := sitemapCtrl{}
+	g := router.NewGroup("")
+
+	g.GET("/sitemap.xml", ctrl.index)
+	g.GET("/sitemaps/:sitemapName", ctrl.detail)
+}
+
+func formatSitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newSitemapURLs create the urls of path(simplified and traditional) with alternates
+func newSitemapURLs(path string, lastMod time.Time) []*sitemapURL {
+	langAlternates := getLangAlternates(path)
+	alternates := make([]*sitemapAlternate, len(langAlternates))
+	for i, item := range langAlternates {
+		alternates[i] = &sitemapAlternate{
+			Rel:      "alternate",
+			HrefLang: item.Lang,
+			Href:     item.URL,
+		}
+	}
+	urls := make([]*sitemapURL, 0, len(langAlternates))
+	for _, item := range langAlternates {
+		// x-default与简体的url相同
+		if item.Lang == ssrDefaultLang {
+			continue
+		}
+		urls = append(urls, &sitemapURL{
+			Loc:        item.URL,
+			LastMod:    formatSitemapTime(lastMod),
+			Alternates: alternates,
+		})
+	}
+	return urls
+}
+
+func marshalSitemap(v interface{}) (buf []byte, err error) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return
+	}
+	buf = append([]byte(xml.Header), data...)
+	return
+}
+
+// getSitemap get the sitemap from cache, it will be generated if the content is changed
+func getSitemap(name string, fn func() ([]byte, error)) (buf []byte, err error) {
+	version, err := bookSrv.GetSitemapVersion()
+	if err != nil {
+		return
+	}
+	sitemapCacheMutex.RLock()
+	item := sitemapCache[name]
+	sitemapCacheMutex.RUnlock()
+	if item != nil && item.version == version {
+		return item.buf, nil
+	}
+	buf, err = fn()
+	if err != nil {
+		return
+	}
+	sitemapCacheMutex.Lock()
+	sitemapCache[name] = &sitemapCacheItem{
+		version: version,
+		buf:     buf,
+	}
+	sitemapCacheMutex.Unlock()
+	return
+}
+
+func setSitemapBody(c *elton.Context, buf []byte) {
+	c.CacheMaxAge("5m")
+	c.SetContentTypeByExt(".xml")
+	c.BodyBuffer = bytes.NewBuffer(buf)
+}
+
+// index the sitemap index of pages and paged chapters
+func (ctrl sitemapCtrl) index(c *elton.Context) (err error) {
+	buf, err := getSitemap("index", func() ([]byte, error) {
+		books, err := bookSrv.ListSitemapBooks()
+		if err != nil {
+			return nil, err
+		}
+		var lastMod time.Time
+		for _, book := range books {
+			if book.UpdatedAt.After(lastMod) {
+				lastMod = book.UpdatedAt
+			}
+		}
+		pages, err := bookSrv.ListSitemapChapterPages(sitemapChapterLimit)
+		if err != nil {
+			return nil, err
+		}
+		index := &sitemapIndex{
+			XMLNS: sitemapXMLNS,
+			Sitemaps: []*sitemapItem{
+				{
+					Loc:     getAbsoluteURL("/sitemaps/" + sitemapPagesName),
+					LastMod: formatSitemapTime(lastMod),
+				},
+			},
+		}
+		for _, page := range pages {
+			index.Sitemaps = append(index.Sitemaps, &sitemapItem{
+				Loc:     getAbsoluteURL("/sitemaps/" + fmt.Sprintf(sitemapChapterName, page.Page)),
+				LastMod: formatSitemapTime(page.LastMod),
+			})
+		}
+		return marshalSitemap(index)
+	})
+	if err != nil {
+		return
+	}
+	setSitemapBody(c, buf)
+	return
+}
+
+// detail the sitemap of pages or chapters
+func (ctrl sitemapCtrl) detail(c *elton.Context) (err error) {
+	name := c.Param("sitemapName")
+	var fn func() ([]byte, error)
+	if name == sitemapPagesName {
+		fn = ctrl.pages
+	} else {
+		page, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "chapters-"), ".xml"))
+		fn = func() ([]byte, error) {
+			return ctrl.chapters(page)
+		}
+	}
+	buf, err := getSitemap(name, fn)
+	if err != nil {
+		return
+	}
+	setSitemapBody(c, buf)
+	return
+}
+
+// pages the sitemap of home, tag and book pages
+func (ctrl sitemapCtrl) pages() (buf []byte, err error) {
+	books, err := bookSrv.ListSitemapBooks()
+	if err != nil {
+		return
+	}
+	tags, err := tagSrv.List()
+	if err != nil {
+		return
+	}
+	var lastMod time.Time
+	for _, book := range books {
+		if book.UpdatedAt.After(lastMod) {
+			lastMod = book.UpdatedAt
+		}
+	}
+	urlSet := &sitemapURLSet{
+		XMLNS: sitemapXMLNS,
+		XHTML: sitemapXHTMLXMLNS,
+	}
+	urlSet.URLs = append(urlSet.URLs, newSitemapURLs("/", lastMod)...)
+	for _, tag := range tags {
+		// 无书籍的标签不加入sitemap
+		if tag.BookCount == 0 {
+			continue
+		}
+		urlSet.URLs = append(urlSet.URLs, newSitemapURLs(fmt.Sprintf(tagDetailURL, tag.Slug), tag.UpdatedAt)...)
+	}
+	for _, book := range books {
+		urlSet.URLs = append(urlSet.URLs, newSitemapURLs(fmt.Sprintf(bookDetailURL, book.ID), book.UpdatedAt)...)
+	}
+	return marshalSitemap(urlSet)
+}
+
+// chapters the sitemap of chapters
+func (ctrl sitemapCtrl) chapters(page int) (buf []byte, err error) {
+	chapters, err := bookSrv.ListSitemapChapters(page, sitemapChapterLimit)
+	if err != nil {
+		return
+	}
+	if len(chapters) == 0 {
+		err = errSitemapNotFound
+		return
+	}
+	urlSet := &sitemapURLSet{
+		XMLNS: sitemapXMLNS,
+		XHTML: sitemapXHTMLXMLNS,
+	}
+	for _, chapter := range chapters {
+		urlSet.URLs = append(urlSet.URLs, newSitemapURLs(fmt.Sprintf(bookChapterURL, chapter.BookID, chapter.NO), chapter.UpdatedAt)...)
+	}
+	return marshalSitemap(urlSet)
+}
@@ -0,0 +1,371 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/config"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/service"
+)
+
+const (
+	// 默认的页面描述
+	ssrDefaultDescription = "你我的科幻最爱，尽在卫斯理"
+	// 页面描述的最大字数
+	ssrDescriptionMaxLength = 120
+	// 未匹配语言时使用的hreflang（简体）
+	ssrDefaultLang = "x-default"
+
+	// 公共布局，head与content分别插入至index.html的{HEAD}与{CONTENT}
+	ssrLayoutTemplate = `{{define "head"}}<title>{{.Title}}</title>
+    <meta name="description" content="{{.Description}}" />
+    {{- if .Canonical}}
+    <link rel="canonical" href="{{.Canonical}}" />
+    {{- end}}
+    {{- range .Alternates}}
+    <link rel="alternate" hreflang="{{.Lang}}" href="{{.URL}}" />
+    {{- end}}
+    {{- range .Meta}}
+    {{if .Property}}<meta property="{{.Property}}" content="{{.Content}}" />{{else}}<meta name="{{.Name}}" content="{{.Content}}" />{{end}}
+    {{- end}}
+    {{- if .JSONLD}}
+    <script type="application/ld+json">{{.JSONLD}}</script>
+    {{- end}}
+{{- end}}
+{{define "links"}}{{range .}}<a href="{{.URL}}"{{if .Rel}} rel="{{.Rel}}"{{end}}>{{.Text}}</a> {{end}}{{end}}
+{{define "bookList"}}<ul>{{range .}}<li><h3><a href="{{.URL}}">{{.Name}}</a></h3><p>{{.Summary}}</p></li>{{end}}</ul>{{end}}`
+
+	ssrHomeTemplate = `{{define "content"}}{{template "bookList" .Books}}{{end}}`
+
+	ssrTagTemplate = `{{define "content"}}<h2>{{.Tag.Name}}</h2>
+		<p>{{.Tag.Description}}</p>
+		{{template "bookList" .Books}}
+	{{end}}`
+
+	ssrBookTemplate = `{{define "content"}}<h3>{{.Book.Name}}</h3>
+		<p>{{.Book.Author}}</p>
+		<p>{{.Book.Summary}}</p>
+		<ul>{{range .Chapters}}<li><a href="{{.URL}}">{{.Text}}</a></li>{{end}}</ul>
+		<p>{{template "links" .Pages}}</p>
+	{{end}}`
+
+	// 管理后台无服务端渲染的内容
+	ssrAdminTemplate = `{{define "content"}}{{end}}`
+
+	ssrChapterTemplate = `{{define "content"}}<h4>{{.Chapter.Title}}</h4>
+		<div>{{range .Paragraphs}}<p>{{.}}</p>{{end}}</div>
+		<p>{{template "links" .Links}}</p>
+	{{end}}`
+)
+
+type (
+	// ssrPage the page data of server side render
+	ssrPage struct {
+		Title       string
+		Description string
+		// 页面的路径（不包括语言前缀），用于生成canonical与hreflang
+		Path string
+		// open graph的类型，默认为website
+		Type string
+		// 分享的图片
+		Image string
+		// schema.org的结构化数据
+		JSONLD interface{}
+		// 页面内容的数据
+		Data interface{}
+
+		Canonical  string
+		Alternates []*ssrAlternate
+		Meta       []*ssrMeta
+	}
+	ssrAlternate struct {
+		Lang string
+		URL  string
+	}
+	ssrMeta struct {
+		Name     string
+		Property string
+		Content  string
+	}
+	ssrLink struct {
+		URL  string
+		Text string
+		Rel  string
+	}
+	ssrBookItem struct {
+		URL     string
+		Name    string
+		Summary string
+	}
+	// ssrShell the static pieces of index.html around the placeholders
+	ssrShell struct {
+		prefix []byte
+		middle []byte
+		suffix []byte
+	}
+)
+
+var (
+	headPlaceholder    = []byte("{HEAD}")
+	contentPlaceholder = []byte("{CONTENT}")
+
+	errSSRShellInvalid = hes.New("the placeholders of index.html are invalid")
+
+	ssrShellOnce    sync.Once
+	currentSSRShell *ssrShell
+	ssrShellErr     error
+
+	ssrLayout = template.Must(template.New("layout").Parse(ssrLayoutTemplate))
+
+	ssrHome    = newSSRTemplate(ssrHomeTemplate)
+	ssrTag     = newSSRTemplate(ssrTagTemplate)
+	ssrBook    = newSSRTemplate(ssrBookTemplate)
+	ssrChapter = newSSRTemplate(ssrChapterTemplate)
+	ssrAdmin   = newSSRTemplate(ssrAdminTemplate)
+)
+
+// ogLocales the open graph locale of lang
+var ogLocales = map[string]string{
+	cs.LangSC: "zh_CN",
+	cs.LangTC: "zh_TW",
+	cs.LangTW: "zh_TW",
+	cs.LangHK: "zh_HK",
+}
+
+// newSSRTemplate create the template of page with the shared layout
+func newSSRTemplate(content string) *template.Template {
+	return template.Must(template.Must(ssrLayout.Clone()).Parse(content))
+}
+
+// truncateText truncate the text to max length(runes) for description
+func truncateText(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "..."
+}
+
+// isTCLang check the page is traditional chinese(include regional variants)
+func isTCLang(c *elton.Context) bool {
+	return service.IsTraditionalLang(getLang(c))
+}
+
+// getPageLang get the lang of page
+func getPageLang(c *elton.Context) string {
+	if isTCLang(c) {
+		return getLang(c)
+	}
+	return cs.LangSC
+}
+
+// getAbsoluteURL get the absolute url of path
+func getAbsoluteURL(path string) string {
+	if path == "" ||
+		strings.HasPrefix(path, "http://") ||
+		strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return config.GetSiteURL() + path
+}
+
+// getLangAlternates get the urls of path for each lang(simplified, traditional and x-default),
+// it is used by the hreflang of page and sitemap
+func getLangAlternates(path string) []*ssrAlternate {
+	scURL := getAbsoluteURL(path)
+	alternates := []*ssrAlternate{
+		{
+			Lang: cs.LangSC,
+			URL:  scURL,
+		},
+	}
+	for _, lang := range service.GetTraditionalLangs() {
+		alternates = append(alternates, &ssrAlternate{
+			Lang: lang,
+			URL:  getAbsoluteURL("/" + lang + path),
+		})
+	}
+	return append(alternates, &ssrAlternate{
+		Lang: ssrDefaultLang,
+		URL:  scURL,
+	})
+}
+
+// fillSEO fill the canonical, hreflang alternates, open graph and twitter card of page
+func fillSEO(c *elton.Context, page *ssrPage) {
+	if page.Path == "" {
+		return
+	}
+	lang := getPageLang(c)
+	page.Alternates = getLangAlternates(page.Path)
+	page.Canonical = getAbsoluteURL(page.Path)
+	for _, item := range page.Alternates {
+		if item.Lang == lang {
+			page.Canonical = item.URL
+		}
+	}
+	locale := ogLocales[lang]
+	ogType := page.Type
+	if ogType == "" {
+		ogType = "website"
+	}
+	twitterCard := "summary"
+	image := getAbsoluteURL(page.Image)
+	if image != "" {
+		twitterCard = "summary_large_image"
+	}
+	meta := []*ssrMeta{
+		{Property: "og:type", Content: ogType},
+		{Property: "og:site_name", Content: ssrSiteName},
+		{Property: "og:locale", Content: locale},
+		{Property: "og:title", Content: page.Title},
+		{Property: "og:description", Content: page.Description},
+		{Property: "og:url", Content: page.Canonical},
+		{Name: "twitter:card", Content: twitterCard},
+		{Name: "twitter:title", Content: page.Title},
+		{Name: "twitter:description", Content: page.Description},
+	}
+	if image != "" {
+		meta = append(meta,
+			&ssrMeta{Property: "og:image", Content: image},
+			&ssrMeta{Name: "twitter:image", Content: image},
+		)
+	}
+	// 预览的页面不允许搜索引擎收录
+	if isPreview(c) {
+		meta = append(meta, &ssrMeta{Name: "robots", Content: "noindex"})
+	}
+	page.Meta = append(meta, page.Meta...)
+}
+
+// newBookJSONLD create the schema.org Book data of book
+func newBookJSONLD(c *elton.Context, book *service.Book) map[string]interface{} {
+	data := map[string]interface{}{
+		"@context":     "https://schema.org",
+		"@type":        "Book",
+		"name":         book.Name,
+		"url":          getAbsoluteURL(formatLangURL(c, bookDetailURL, book.ID)),
+		"inLanguage":   getPageLang(c),
+		"dateModified": book.UpdatedAt.Format(time.RFC3339),
+		"author": map[string]interface{}{
+			"@type": "Person",
+			"name":  book.Author,
+		},
+	}
+	if book.Summary != "" {
+		data["description"] = book.Summary
+	}
+	data["image"] = getAbsoluteURL(getBookCover(c, book))
+	if book.RatingCount != 0 {
+		data["aggregateRating"] = map[string]interface{}{
+			"@type":       "AggregateRating",
+			"ratingValue": book.Rating,
+			"ratingCount": book.RatingCount,
+			"bestRating":  5,
+			"worstRating": 1,
+		}
+	}
+	return data
+}
+
+// newChapterJSONLD create the schema.org Chapter data of chapter
+func newChapterJSONLD(c *elton.Context, book *service.Book, chapter *service.Chapter) map[string]interface{} {
+	return map[string]interface{}{
+		"@context":     "https://schema.org",
+		"@type":        "Chapter",
+		"name":         chapter.Title,
+		"position":     chapter.NO + 1,
+		"url":          getAbsoluteURL(formatLangURL(c, bookChapterURL, book.ID, chapter.NO)),
+		"inLanguage":   getPageLang(c),
+		"dateModified": chapter.UpdatedAt.Format(time.RFC3339),
+		"isPartOf": map[string]interface{}{
+			"@type": "Book",
+			"name":  book.Name,
+			"url":   getAbsoluteURL(formatLangURL(c, bookDetailURL, book.ID)),
+			"author": map[string]interface{}{
+				"@type": "Person",
+				"name":  book.Author,
+			},
+		},
+	}
+}
+
+// getSSRShell get the shell of index.html, it is split by the placeholders only once,
+// so the rendered content is never searched for placeholders
+func getSSRShell() (*ssrShell, error) {
+	ssrShellOnce.Do(func() {
+		buf, err := box.Find(indexFile)
+		if err != nil {
+			ssrShellErr = err
+			return
+		}
+		headIndex := bytes.Index(buf, headPlaceholder)
+		contentIndex := bytes.Index(buf, contentPlaceholder)
+		if headIndex < 0 || contentIndex < headIndex+len(headPlaceholder) {
+			ssrShellErr = errSSRShellInvalid
+			return
+		}
+		currentSSRShell = &ssrShell{
+			prefix: buf[:headIndex],
+			middle: buf[headIndex+len(headPlaceholder) : contentIndex],
+			suffix: buf[contentIndex+len(contentPlaceholder):],
+		}
+	})
+	return currentSSRShell, ssrShellErr
+}
+
+// renderSSR render the page and inline it into index.html
+func renderSSR(c *elton.Context, tpl *template.Template, page *ssrPage) (err error) {
+	shell, err := getSSRShell()
+	if err != nil {
+		return
+	}
+	if page.Description == "" {
+		page.Description = ssrDefaultDescription
+	}
+	fillSEO(c, page)
+	head := new(bytes.Buffer)
+	err = tpl.ExecuteTemplate(head, "head", page)
+	if err != nil {
+		return
+	}
+	content := new(bytes.Buffer)
+	err = tpl.ExecuteTemplate(content, "content", page.Data)
+	if err != nil {
+		return
+	}
+	buf := new(bytes.Buffer)
+	buf.Grow(len(shell.prefix) + head.Len() + len(shell.middle) + content.Len() + len(shell.suffix))
+	buf.Write(shell.prefix)
+	buf.Write(head.Bytes())
+	buf.Write(shell.middle)
+	buf.Write(content.Bytes())
+	buf.Write(shell.suffix)
+	c.SetContentTypeByExt(indexFile)
+	c.BodyBuffer = buf
+	return
+}
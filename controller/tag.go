@@ -0,0 +1,132 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"strconv"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/router"
+	"github.com/vicanso/wsl/service"
+	"github.com/vicanso/wsl/validate"
+)
+
+type (
+	tagCtrl struct{}
+
+	addTagParams struct {
+		Name        string `json:"name,omitempty" valid:"xTagName"`
+		Slug        string `json:"slug,omitempty" valid:"xTagSlug"`
+		Description string `json:"description,omitempty" valid:"xTagDescription,optional"`
+	}
+	updateTagParams struct {
+		Name        string `json:"name,omitempty" valid:"xTagName,optional"`
+		Slug        string `json:"slug,omitempty" valid:"xTagSlug,optional"`
+		Description string `json:"description,omitempty" valid:"xTagDescription,optional"`
+	}
+)
+
+func init() {
+	ctrl := tagCtrl{}
+	g := router.NewGroup("/tags")
+
+	// 获取所有标签（包括书籍数量）
+	g.GET("/v1", ctrl.list)
+	g.POST(
+		"/v1",
+		newTracker(cs.ActionTagAdd),
+		shouldBeAdmin,
+		ctrl.add,
+	)
+	g.PATCH(
+		"/v1/:tagID",
+		newTracker(cs.ActionTagUpdate),
+		shouldBeAdmin,
+		ctrl.update,
+	)
+	g.DELETE(
+		"/v1/:tagID",
+		newTracker(cs.ActionTagDelete),
+		shouldBeAdmin,
+		ctrl.delete,
+	)
+}
+
+// list list tags
+func (ctrl tagCtrl) list(c *elton.Context) (err error) {
+	result, err := tagSrv.List()
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("1m")
+	c.Body = &struct {
+		Tags []*service.Tag `json:"tags,omitempty"`
+	}{
+		result,
+	}
+	return
+}
+
+// add add tag
+func (ctrl tagCtrl) add(c *elton.Context) (err error) {
+	params := &addTagParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	tag := &service.Tag{
+		Name:        params.Name,
+		Slug:        params.Slug,
+		Description: params.Description,
+	}
+	err = tagSrv.Add(tag)
+	if err != nil {
+		return
+	}
+	c.Created(tag)
+	return
+}
+
+// update update tag
+func (ctrl tagCtrl) update(c *elton.Context) (err error) {
+	params := &updateTagParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("tagID"))
+	err = tagSrv.UpdateByID(uint(id), service.Tag{
+		Name:        params.Name,
+		Slug:        params.Slug,
+		Description: params.Description,
+	})
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// delete delete tag
+func (ctrl tagCtrl) delete(c *elton.Context) (err error) {
+	id, _ := strconv.Atoi(c.Param("tagID"))
+	err = tagSrv.DeleteByID(uint(id))
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/vicanso/wsl/router"
 	"github.com/vicanso/wsl/service"
 	"github.com/vicanso/wsl/util"
+	"go.uber.org/zap"
 )
 
 type (
@@ -64,6 +65,20 @@ type (
 	updateUserParams struct {
 		Roles []string `json:"roles,omitempty" valid:"xUserRoles,optional"`
 	}
+	updateReadingProgressParams struct {
+		NO           int        `json:"no,omitempty" valid:"xBookChapterNO,optional"`
+		ScrollOffset int        `json:"scrollOffset,omitempty" valid:"xReadingScrollOffset,optional"`
+		ReadAt       *time.Time `json:"readAt,omitempty" valid:"-"`
+	}
+	listReadingProgressParams struct {
+		Limit string `json:"limit,omitempty" valid:"xLimit"`
+	}
+	addShelfBookParams struct {
+		BookID uint `json:"bookID,omitempty" valid:"xBookID"`
+	}
+	reorderShelfParams struct {
+		BookIDs []uint `json:"bookIDs,omitempty" valid:"xBookIDs"`
+	}
 	listUserLoginRecordParams struct {
 		Begin   time.Time `json:"begin,omitempty" valid:"-"`
 		End     time.Time `json:"end,omitempty" valid:"-"`
@@ -145,6 +160,47 @@ func init() {
 		ctrl.logout,
 	)
 
+	// 获取阅读进度（继续阅读）列表
+	g.GET(
+		"/v1/me/progresses",
+		ctrl.listReadingProgress,
+	)
+	// 获取书籍的阅读进度
+	g.GET(
+		"/v1/me/progresses/:bookID",
+		ctrl.getReadingProgress,
+	)
+	// 更新书籍的阅读进度（未登录则记录至track id）
+	g.PATCH(
+		"/v1/me/progresses/:bookID",
+		ctrl.updateReadingProgress,
+	)
+
+	// 获取书架（需要登录）
+	g.GET(
+		"/v1/me/shelf",
+		shouldLogined,
+		ctrl.listShelf,
+	)
+	// 添加书籍至书架
+	g.POST(
+		"/v1/me/shelf",
+		shouldLogined,
+		ctrl.addShelfBook,
+	)
+	// 调整书架的书籍顺序
+	g.PATCH(
+		"/v1/me/shelf",
+		shouldLogined,
+		ctrl.reorderShelf,
+	)
+	// 从书架删除书籍
+	g.DELETE(
+		"/v1/me/shelf/:bookID",
+		shouldLogined,
+		ctrl.removeShelfBook,
+	)
+
 	// 获取客户登录记录
 	g.GET(
 		"/v1/login-records",
@@ -264,6 +320,14 @@ func (ctrl userCtrl) login(c *elton.Context) (err error) {
 		XForwardedFor: c.GetRequestHeader("X-Forwarded-For"),
 	}
 	userSrv.AddLoginRecord(loginRecord)
+	// 将未登录时的阅读进度合并至账号
+	e := readingSrv.MergeTrackProgress(util.GetTrackID(c), u.Account)
+	if e != nil {
+		logger.Error("merge reading progress fail",
+			zap.String("account", u.Account),
+			zap.Error(e),
+		)
+	}
 	omitUserInfo(u)
 	us.SetAccount(u.Account)
 	us.SetRoles(u.Roles)
@@ -372,6 +436,127 @@ func (ctrl userCtrl) update(c *elton.Context) (err error) {
 	return
 }
 
+// getReadingOwner get the owner of reading progress
+func getReadingOwner(c *elton.Context) service.ReadingOwner {
+	return service.ReadingOwner{
+		Account: getUserSession(c).GetAccount(),
+		TrackID: getTrackID(c),
+	}
+}
+
+// listReadingProgress list reading progress
+func (ctrl userCtrl) listReadingProgress(c *elton.Context) (err error) {
+	params := &listReadingProgressParams{}
+	err = validate.Do(params, c.Query())
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(params.Limit)
+	result, err := readingSrv.ListProgress(service.ReadingProgressQueryParams{
+		Owner: getReadingOwner(c),
+		Limit: limit,
+	})
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Progresses []*service.ReadingProgress `json:"progresses,omitempty"`
+	}{
+		result,
+	}
+	return
+}
+
+// getReadingProgress get reading progress of book
+func (ctrl userCtrl) getReadingProgress(c *elton.Context) (err error) {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	progress, err := readingSrv.GetProgress(getReadingOwner(c), uint(bookID))
+	if err != nil {
+		return
+	}
+	c.Body = progress
+	return
+}
+
+// updateReadingProgress update reading progress of book
+func (ctrl userCtrl) updateReadingProgress(c *elton.Context) (err error) {
+	params := &updateReadingProgressParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	progress := &service.ReadingProgress{
+		BookID:       uint(bookID),
+		NO:           uint(params.NO),
+		ScrollOffset: params.ScrollOffset,
+	}
+	if params.ReadAt != nil {
+		progress.ReadAt = *params.ReadAt
+	}
+	err = readingSrv.UpsertProgress(getReadingOwner(c), progress)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listShelf list the books of shelf
+func (ctrl userCtrl) listShelf(c *elton.Context) (err error) {
+	result, err := shelfSrv.List(getUserSession(c).GetAccount())
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Books []*service.ShelfItem `json:"books,omitempty"`
+	}{
+		result,
+	}
+	return
+}
+
+// addShelfBook add book to shelf
+func (ctrl userCtrl) addShelfBook(c *elton.Context) (err error) {
+	params := &addShelfBookParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = shelfSrv.Add(getUserSession(c).GetAccount(), params.BookID)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// reorderShelf reorder the books of shelf
+func (ctrl userCtrl) reorderShelf(c *elton.Context) (err error) {
+	params := &reorderShelfParams{}
+	err = validate.Do(params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = shelfSrv.Reorder(getUserSession(c).GetAccount(), params.BookIDs)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// removeShelfBook remove book from shelf
+func (ctrl userCtrl) removeShelfBook(c *elton.Context) (err error) {
+	bookID, _ := strconv.Atoi(c.Param("bookID"))
+	err = shelfSrv.Remove(getUserSession(c).GetAccount(), uint(bookID))
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
 // listLoginRecord list login record
 func (ctrl userCtrl) listLoginRecord(c *elton.Context) (err error) {
 	params := &listUserLoginRecordParams{}
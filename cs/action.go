@@ -31,4 +31,37 @@ const (
 
 	// ActionBookUpdate update book
 	ActionBookUpdate = "update-book"
+	// ActionBookSync sync book from file
+	ActionBookSync = "sync-book"
+	// ActionBookSyncJobCancel cancel book sync job
+	ActionBookSyncJobCancel = "cancel-book-sync-job"
+	// ActionBookSyncArchive sync book from archive
+	ActionBookSyncArchive = "sync-book-archive"
+	// ActionBookReview add or update book review
+	ActionBookReview = "review-book"
+
+	// ActionChapterAdd add chapter
+	ActionChapterAdd = "add-chapter"
+	// ActionChapterUpdate update chapter
+	ActionChapterUpdate = "update-chapter"
+	// ActionChapterDelete delete chapter
+	ActionChapterDelete = "delete-chapter"
+	// ActionChapterMove move chapter
+	ActionChapterMove = "move-chapter"
+	// ActionChapterRollback rollback chapter
+	ActionChapterRollback = "rollback-chapter"
+
+	// ActionTagAdd add tag
+	ActionTagAdd = "add-tag"
+	// ActionTagUpdate update tag
+	ActionTagUpdate = "update-tag"
+	// ActionTagDelete delete tag
+	ActionTagDelete = "delete-tag"
+
+	// ActionCommentAdd add comment
+	ActionCommentAdd = "add-comment"
+	// ActionCommentReport report comment
+	ActionCommentReport = "report-comment"
+	// ActionCommentReview review comment
+	ActionCommentReview = "review-comment"
 )
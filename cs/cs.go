@@ -19,6 +19,12 @@ const (
 	CID = "cid"
 	// UserSession user session
 	UserSession = "userSession"
+	// Preview preview the unpublished content
+	Preview = "preview"
+	// Lang the lang of request
+	Lang = "lang"
+	// S2TDisabled the response should not be converted to traditional chinese
+	S2TDisabled = "s2tDisabled"
 
 	// UserRoleSu super user
 	UserRoleSu = "su"
@@ -34,6 +40,68 @@ const (
 )
 
 const (
+	// BookSyncJobRunning 同步中
+	BookSyncJobRunning = iota + 1
+	// BookSyncJobDone 同步完成
+	BookSyncJobDone
+	// BookSyncJobFailed 同步失败
+	BookSyncJobFailed
+	// BookSyncJobCanceled 同步已取消
+	BookSyncJobCanceled
+)
+
+const (
+	// BookSyncSourceFile 从服务器目录同步
+	BookSyncSourceFile = "file"
+	// BookSyncSourceArchive 从上传的压缩包同步
+	BookSyncSourceArchive = "archive"
+)
+
+const (
+	// CommentPending 待审核
+	CommentPending = iota + 1
+	// CommentApproved 已通过
+	CommentApproved
+	// CommentHidden 已隐藏
+	CommentHidden
+)
+
+const (
+	// PublishDraft 草稿
+	PublishDraft = iota + 1
+	// PublishScheduled 定时发布
+	PublishScheduled
+	// PublishPublished 已发布
+	PublishPublished
+)
+
+const (
+	// ChapterEditAdd 添加章节
+	ChapterEditAdd = "add"
+	// ChapterEditUpdate 更新章节
+	ChapterEditUpdate = "update"
+	// ChapterEditDelete 删除章节
+	ChapterEditDelete = "delete"
+	// ChapterEditMove 移动章节
+	ChapterEditMove = "move"
+	// ChapterEditRollback 回滚章节
+	ChapterEditRollback = "rollback"
+)
+
+const (
+	// TagModeAnd 包含所有标签
+	TagModeAnd = "and"
+	// TagModeOr 包含任一标签
+	TagModeOr = "or"
+)
+
+const (
+	// LangSC 简体中文
+	LangSC = "zh-Hans"
 	// LangTC 繁体中文
 	LangTC = "zh-Hant"
+	// LangTW 繁体中文（台湾）
+	LangTW = "zh-Hant-TW"
+	// LangHK 繁体中文（香港）
+	LangHK = "zh-Hant-HK"
 )
@@ -7,12 +7,15 @@ require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/gobuffalo/packr/v2 v2.5.2
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
+	github.com/hashicorp/golang-lru v0.5.3
 	github.com/jinzhu/gorm v1.9.10
 	github.com/json-iterator/go v1.1.7
 	github.com/lib/pq v1.2.0
 	github.com/liuzl/cedar-go v0.0.0-20170805034717-80a9c64b256d // indirect
 	github.com/liuzl/da v0.0.0-20180704015230-14771aad5b1d // indirect
 	github.com/llgcode/draw2d v0.0.0-20190810100245-79e59b6b8fbc
+	github.com/mozillazg/go-pinyin v0.15.0
 	github.com/oklog/ulid v1.3.1
 	github.com/spf13/viper v1.4.0
 	github.com/vicanso/count-warner v0.0.1
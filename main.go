@@ -35,7 +35,6 @@ import (
 
 	"github.com/vicanso/wsl/config"
 	_ "github.com/vicanso/wsl/controller"
-	"github.com/vicanso/wsl/cs"
 	_ "github.com/vicanso/wsl/helper"
 	"github.com/vicanso/wsl/log"
 	"github.com/vicanso/wsl/middleware"
@@ -101,18 +100,27 @@ func main() {
 		resp.Write([]byte(`{"statusCode": 404,"message": "Not found"}`))
 		warner404.Inc(ip, 1)
 	}
-	tcLang := "/" + cs.LangTC
+	// 繁体语言（较长的在前，避免/zh-Hant匹配/zh-Hant-TW）
+	tcLangs := service.GetTraditionalLangs()
 	d.Pre(func(req *http.Request) {
 		path := req.URL.Path
 		// 如果url是以繁体前缀，则转换为query
-		if strings.HasPrefix(path, tcLang) {
-			req.URL.Path = path[len(tcLang):]
+		for _, lang := range tcLangs {
+			prefix := "/" + lang
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				continue
+			}
+			req.URL.Path = path[len(prefix):]
+			if req.URL.Path == "" {
+				req.URL.Path = "/"
+			}
 			rawQuery := req.URL.RawQuery
 			if rawQuery == "" {
-				req.URL.RawQuery = "lang=" + cs.LangTC
+				req.URL.RawQuery = "lang=" + lang
 			} else {
-				req.URL.RawQuery += ("&lang=" + cs.LangTC)
+				req.URL.RawQuery += ("&lang=" + lang)
 			}
+			break
 		}
 	})
 
@@ -171,7 +179,12 @@ func main() {
 	d.Use(responder.NewDefault())
 
 	// 读取读取body的数的，转换为json bytes
-	d.Use(bodyparser.NewDefault())
+	// 章节编辑时提交的内容较大，因此调整限制为2MB（默认为50KB）
+	d.Use(bodyparser.New(bodyparser.Config{
+		IgnoreFormURLEneltoned: true,
+		Deeltone:               bodyparser.DefaultDeeltone,
+		Limit:                  2 * 1024 * 1024,
+	}))
 
 	// 初始化路由
 	router.Init(d)
@@ -20,7 +20,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/vicanso/gocc"
 	"github.com/vicanso/elton"
 	"github.com/vicanso/hes"
 	"github.com/vicanso/wsl/cs"
@@ -43,34 +42,112 @@ var (
 		Message:    "captcha is invalid",
 		Category:   errCategory,
 	}
+)
 
-	// 简体转繁体
-	s2tOpenCC *gocc.OpenCC
+var (
+	// 根据Accept-Language选择语言的页面（接口只通过lang参数或url前缀指定，
+	// 避免管理后台获取繁体数据后保存）
+	negotiatePathPrefixes = []string{
+		"/tag/",
+		"/book/",
+		"/feeds/",
+		"/opds/",
+	}
 )
 
-func init() {
-	openCC, err := gocc.New("s2t")
-	if err != nil {
-		panic(err)
+// isNegotiablePath check the path is page which can negotiate the lang
+func isNegotiablePath(path string) bool {
+	if path == "/" {
+		return true
+	}
+	for _, prefix := range negotiatePathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTextContentType 判断是否文本类型的响应（epub、图片等二进制数据不需要转换）
+func isTextContentType(contentType string) bool {
+	// 未设置类型的响应数据默认为文本
+	if contentType == "" {
+		return true
+	}
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	return strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml") ||
+		strings.Contains(contentType, "javascript")
+}
+
+// getS2TETagSuffix get the suffix of etag for converted response,
+// the etag is changed when the lang or s2t dict is changed
+func getS2TETagSuffix(lang string) string {
+	return "-" + lang + "-" + service.GetS2TDictVersion()
+}
+
+// stripS2TETagSuffix strip the suffix of etags in If-None-Match,
+// the etag which is not generated with the suffix will be removed
+func stripS2TETagSuffix(value, suffix string) string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasSuffix(tag, suffix+`"`) {
+			tags = append(tags, tag[:len(tag)-len(suffix)-1]+`"`)
+		}
 	}
-	s2tOpenCC = openCC
+	return strings.Join(tags, ", ")
 }
 
-// NewS2TConverter create a s2t converter
+// NewS2TConverter create a s2t converter, the lang is from query(url prefix)
+// or negotiated from accept language(pages only), and the converted body is cached by etag
 func NewS2TConverter() elton.Handler {
 
 	return func(c *elton.Context) (err error) {
 		lang := c.QueryParam("lang")
+		// 未指定语言时，页面根据Accept-Language选择
+		if lang == "" && isNegotiablePath(c.Request.URL.Path) {
+			lang = service.NegotiateLang(c.GetRequestHeader("Accept-Language"))
+			c.AddHeader("Vary", "Accept-Language")
+		}
+		c.Set(cs.Lang, lang)
+		traditional := service.IsTraditionalLang(lang)
+		suffix := ""
+		if traditional {
+			suffix = getS2TETagSuffix(lang)
+			// 繁体的ETag添加了语言与词组覆盖版本，需要还原后再判断是否fresh
+			// 词组覆盖调整后的ETag则删除，避免返回过期的304
+			ifNoneMatch := c.GetRequestHeader(elton.HeaderIfNoneMatch)
+			if ifNoneMatch != "" {
+				ifNoneMatch = stripS2TETagSuffix(ifNoneMatch, suffix)
+				if ifNoneMatch == "" {
+					c.Request.Header.Del(elton.HeaderIfNoneMatch)
+				} else {
+					c.SetRequestHeader(elton.HeaderIfNoneMatch, ifNoneMatch)
+				}
+			}
+		}
 		err = c.Next()
-		if err != nil {
+		if err != nil || !traditional {
 			return
 		}
-		if lang == cs.LangTC && c.BodyBuffer != nil && c.BodyBuffer.Len() != 0 {
-			value, _ := s2tOpenCC.Convert(c.BodyBuffer.String())
+		if disabled, _ := c.Get(cs.S2TDisabled).(bool); disabled {
+			return
+		}
+		eTag := c.GetHeader(elton.HeaderETag)
+		if c.BodyBuffer != nil && c.BodyBuffer.Len() != 0 &&
+			isTextContentType(c.GetHeader(elton.HeaderContentType)) {
+			value, _ := service.ConvertLangWithCache(lang, eTag, c.BodyBuffer.String())
 			if value != "" {
 				c.BodyBuffer = bytes.NewBufferString(value)
 			}
 		}
+		// ETag为简体数据生成，添加语言与词组覆盖版本（304的响应也需要添加）
+		if strings.HasSuffix(eTag, `"`) {
+			c.SetHeader(elton.HeaderETag, eTag[:len(eTag)-1]+suffix+`"`)
+		}
 		return
 	}
 }
@@ -66,8 +66,53 @@ func Init(d *elton.Elton) {
 		return nil
 	})
 
+	// 书籍同步任务ID
+	bookSyncJobIDReg := regexp.MustCompile(`^[1-9][0-9]{0,6}$`)
+	d.AddValidator("jobID", func(value string) error {
+		if !bookSyncJobIDReg.MatchString(value) {
+			return hes.New("job id should be numbers")
+		}
+		return nil
+	})
+
+	// 评论ID
+	commentIDReg := regexp.MustCompile(`^[1-9][0-9]{0,9}$`)
+	d.AddValidator("commentID", func(value string) error {
+		if !commentIDReg.MatchString(value) {
+			return hes.New("comment id should be numbers")
+		}
+		return nil
+	})
+
+	// 标签ID
+	tagIDReg := regexp.MustCompile(`^[1-9][0-9]{0,4}$`)
+	d.AddValidator("tagID", func(value string) error {
+		if !tagIDReg.MatchString(value) {
+			return hes.New("tag id should be numbers")
+		}
+		return nil
+	})
+
+	// 标签slug
+	tagSlugReg := regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+	d.AddValidator("tagSlug", func(value string) error {
+		if !tagSlugReg.MatchString(value) {
+			return hes.New("tag slug is invalid")
+		}
+		return nil
+	})
+
+	// 章节修改记录ID
+	revisionIDReg := regexp.MustCompile(`^[1-9][0-9]{0,9}$`)
+	d.AddValidator("revisionID", func(value string) error {
+		if !revisionIDReg.MatchString(value) {
+			return hes.New("revision id should be numbers")
+		}
+		return nil
+	})
+
 	// 书籍章节NO
-	bookNOReg := regexp.MustCompile(`^[0-9]{1,2}$`)
+	bookNOReg := regexp.MustCompile(`^[0-9]{1,5}$`)
 	d.AddValidator("bookChapterNO", func(value string) error {
 		if !bookNOReg.MatchString(value) {
 			return hes.New("book chapter no should be numbers")
@@ -75,4 +120,13 @@ func Init(d *elton.Elton) {
 		return nil
 	})
 
+	// sitemap的名称（书籍与标签页面以及分页的章节）
+	sitemapNameReg := regexp.MustCompile(`^(pages|chapters-[0-9]{1,4})\.xml$`)
+	d.AddValidator("sitemapName", func(value string) error {
+		if !sitemapNameReg.MatchString(value) {
+			return hes.New("sitemap name is invalid")
+		}
+		return nil
+	})
+
 }
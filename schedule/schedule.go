@@ -27,6 +27,9 @@ func init() {
 	go initRedisCheckTicker()
 	go initConfigurationRefreshTicker()
 	go initBookUpdateHotTicker()
+	go initBookFlushViewsTicker()
+	go initPublishScheduledTicker()
+	go initBookSuggestRefreshTicker()
 	// go initInfluxdbCheckTicker()
 	// go initRouterConfigRefreshTicker()
 }
@@ -80,3 +83,30 @@ func initBookUpdateHotTicker() {
 		return bookSrv.UpdateHot()
 	}, initBookUpdateHotTicker)
 }
+
+func initBookFlushViewsTicker() {
+	// 每一分钟将缓存的浏览次数写入数据库
+	bookSrv := new(service.BookSrv)
+	ticker := time.NewTicker(60 * time.Second)
+	runTicker(ticker, "book views flush", func() error {
+		return bookSrv.FlushViews()
+	}, initBookFlushViewsTicker)
+}
+
+func initPublishScheduledTicker() {
+	// 每一分钟发布已到时间的定时书籍与章节
+	bookSrv := new(service.BookSrv)
+	ticker := time.NewTicker(60 * time.Second)
+	runTicker(ticker, "publish scheduled", func() error {
+		return bookSrv.PublishScheduled()
+	}, initPublishScheduledTicker)
+}
+
+func initBookSuggestRefreshTicker() {
+	// 每一分钟检查书籍是否有调整，有则重建书名提示的索引
+	bookSrv := new(service.BookSrv)
+	ticker := time.NewTicker(60 * time.Second)
+	runTicker(ticker, "book suggest index refresh", func() error {
+		return bookSrv.RefreshSuggestIndex()
+	}, initBookSuggestRefreshTicker)
+}
@@ -15,13 +15,18 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/util"
 )
 
 const (
@@ -44,6 +49,22 @@ type (
 		ChapterCount int    `json:"chapterCount,omitempty"`
 		Hot          int    `json:"hot,omitempty"`
 		Cover        string `json:"cover,omitempty"`
+		// 无封面时生成的封面地址（不保存至数据库）
+		GeneratedCover string `json:"generatedCover,omitempty" gorm:"-"`
+		// 浏览热度（按半衰期衰减）
+		ViewScore float64 `json:"viewScore,omitempty" gorm:"not null;default:0"`
+		// 综合热度（浏览热度与人工设置的热度），用于热门排序
+		HotScore float64 `json:"hotScore,omitempty" gorm:"not null;default:0;index:idx_books_hot_score"`
+		// 平均评分与评分人数
+		Rating      float64 `json:"rating,omitempty" gorm:"not null;default:0"`
+		RatingCount int     `json:"ratingCount,omitempty" gorm:"not null;default:0"`
+
+		Tags []*Tag `json:"tags,omitempty" gorm:"many2many:book_tags;"`
+
+		// 发布状态（默认为已发布，与cs.PublishPublished一致）
+		Status int `json:"status,omitempty" gorm:"not null;default:3;index:idx_books_status"`
+		// 定时发布的时间
+		PublishAt *time.Time `json:"publishAt,omitempty"`
 	}
 	// Chapter chapter
 	Chapter struct {
@@ -57,6 +78,13 @@ type (
 		Title     string `json:"title,omitempty"`
 		Content   string `json:"content,omitempty"`
 		WordCount int    `json:"wordCount,omitempty"`
+		// 标题与内容的hash，用于同步时判断章节是否有更新
+		Hash string `json:"hash,omitempty" gorm:"type:varchar(64)"`
+
+		// 发布状态（默认为已发布，与cs.PublishPublished一致）
+		Status int `json:"status,omitempty" gorm:"not null;default:3"`
+		// 定时发布的时间
+		PublishAt *time.Time `json:"publishAt,omitempty"`
 	}
 	// BookQueryParams book query params
 	BookQueryParams struct {
@@ -65,6 +93,12 @@ type (
 		Offset  int
 		Limit   int
 		Keyword string
+		// 标签的slug列表
+		Tags []string
+		// 标签的筛选方式(and, or)
+		TagMode string
+		// 是否包括未发布的书籍（管理员预览）
+		Unpublished bool
 	}
 	// ChapterQueryParams chapter query params
 	ChapterQueryParams struct {
@@ -72,6 +106,39 @@ type (
 		BookID uint
 		Offset int
 		Limit  int
+		// 从此NO开始获取（包括此NO），章节较多时避免使用较大的offset
+		FromNO uint
+		// 是否包括未发布的章节（管理员预览）
+		Unpublished bool
+	}
+	// BookSyncResult the chapter diff of book sync
+	BookSyncResult struct {
+		BookID    uint   `json:"bookID,omitempty"`
+		Name      string `json:"name,omitempty"`
+		Author    string `json:"author,omitempty"`
+		Added     []uint `json:"added,omitempty"`
+		Changed   []uint `json:"changed,omitempty"`
+		Removed   []uint `json:"removed,omitempty"`
+		Unchanged int    `json:"unchanged,omitempty"`
+	}
+	// BookSyncParams book sync params
+	BookSyncParams struct {
+		// Path the directory of books.json
+		Path   string
+		DryRun bool
+		// 触发同步的账号（记录至章节的revision）
+		Account string
+		// OnProgress 同步开始以及每一本书同步完成时回调
+		OnProgress func(done, total int, result *BookSyncResult)
+	}
+	syncBookInfo struct {
+		Name   string
+		Author string
+	}
+	syncChapterInfo struct {
+		Title   string
+		Content string `json:"-"`
+		Hash    string `json:"-"`
 	}
 	// BookSrv book service
 	BookSrv struct {
@@ -83,11 +150,13 @@ func init() {
 		AutoMigrate(&Chapter{})
 }
 
-func updateBookExtraInfo(bookID uint) (err error) {
+// updateBookExtraInfo update the word count and chapter count of book,
+// only the published chapters are counted
+func updateBookExtraInfo(db *gorm.DB, bookID uint) (err error) {
 	var wordCounts []int
-	err = pgGetClient().Model(&Chapter{}).Where(Chapter{
-		BookID: bookID,
-	}).Pluck("word_count", &wordCounts).Error
+	err = db.Model(&Chapter{}).
+		Where("book_id = ? AND status = ?", bookID, cs.PublishPublished).
+		Pluck("word_count", &wordCounts).Error
 	if err != nil {
 		return
 	}
@@ -95,11 +164,12 @@ func updateBookExtraInfo(bookID uint) (err error) {
 	for _, v := range wordCounts {
 		wordCount += v
 	}
-	err = pgGetClient().Model(&Book{
+	// 使用map更新，确保章节全部删除时也能更新为0
+	err = db.Model(&Book{
 		ID: bookID,
-	}).Updates(Book{
-		WordCount:    wordCount,
-		ChapterCount: len(wordCounts),
+	}).Updates(map[string]interface{}{
+		"word_count":    wordCount,
+		"chapter_count": len(wordCounts),
 	}).Error
 	if err != nil {
 		return
@@ -107,84 +177,276 @@ func updateBookExtraInfo(bookID uint) (err error) {
 	return
 }
 
-func updateChapters(path string, book Book) (err error) {
-	chaptersFile := filepath.Join(path, book.Name, "chapters.json")
+// getChapterHash get the hash of chapter's title and content
+func getChapterHash(title, content string) string {
+	return util.Sha256(title + "\n" + content)
+}
+
+// readSyncBooks read the book list of sync directory
+func readSyncBooks(path string) (books []*syncBookInfo, err error) {
+	listFile := filepath.Join(path, "books.json")
+	buf, err := ioutil.ReadFile(listFile)
+	if err != nil {
+		return
+	}
+	books = make([]*syncBookInfo, 0)
+	err = json.Unmarshal(buf, &books)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// getSyncBookDir get the directory of book, the name of book should not be a path
+// and the directory should be in the sync directory
+func getSyncBookDir(path, name string) (dir string, err error) {
+	if name == "" ||
+		strings.ContainsAny(name, `/\`) ||
+		strings.Contains(name, "..") ||
+		strings.HasPrefix(name, ".") {
+		err = errSyncBookNameInvalid
+		return
+	}
+	base := filepath.Clean(path)
+	dir = filepath.Join(base, name)
+	if !strings.HasPrefix(dir, base+string(filepath.Separator)) {
+		err = errSyncBookNameInvalid
+		return
+	}
+	return
+}
+
+// readSyncChapters read the chapters of book from sync directory
+func readSyncChapters(path string, name string) (chapters []*syncChapterInfo, err error) {
+	dir, err := getSyncBookDir(path, name)
+	if err != nil {
+		return
+	}
+	chaptersFile := filepath.Join(dir, "chapters.json")
 	buf, err := ioutil.ReadFile(chaptersFile)
 	if err != nil {
 		return
 	}
-	chapters := make([]*struct {
-		Title string
-	}, 0)
+	chapters = make([]*syncChapterInfo, 0)
 	err = json.Unmarshal(buf, &chapters)
 	if err != nil {
 		return
 	}
 	for index, item := range chapters {
-		file := filepath.Join(path, book.Name, fmt.Sprintf("chapter-%d.txt", index))
+		file := filepath.Join(dir, fmt.Sprintf("chapter-%d.txt", index))
 		buf, err = ioutil.ReadFile(file)
 		if err != nil {
 			return
 		}
-		chapter := Chapter{}
-		content := string(buf)
-
-		err = pgGetClient().Where(Chapter{
-			BookID:    book.ID,
-			NO:        uint(index),
-			Title:     item.Title,
-			Content:   content,
-			WordCount: len(content),
-		}).FirstOrCreate(&chapter).Error
+		item.Content = string(buf)
+		item.Hash = getChapterHash(item.Title, item.Content)
+	}
+	return
+}
+
+// getChapterHashes get the hash of book's chapters, the key is chapter's no
+func getChapterHashes(db *gorm.DB, bookID uint, dryRun bool) (hashes map[uint]*Chapter, err error) {
+	hashes = make(map[uint]*Chapter)
+	if bookID == 0 {
+		return
+	}
+	result := make([]*Chapter, 0)
+	err = db.Select("id, no, hash").Where("book_id = ?", bookID).Find(&result).Error
+	if err != nil {
+		return
+	}
+	for _, item := range result {
+		// 旧数据未记录hash，则根据内容生成
+		if item.Hash == "" {
+			chapter := &Chapter{}
+			err = db.Select("title, content").Where("id = ?", item.ID).First(chapter).Error
+			if err != nil {
+				return
+			}
+			item.Hash = getChapterHash(chapter.Title, chapter.Content)
+			if !dryRun {
+				err = db.Model(&Chapter{}).Where("id = ?", item.ID).UpdateColumn("hash", item.Hash).Error
+				if err != nil {
+					return
+				}
+			}
+		}
+		hashes[item.NO] = item
+	}
+	return
+}
+
+// syncChapters sync the chapters of book, only the changed chapters will be updated
+func syncChapters(db *gorm.DB, bookID uint, chapters []*syncChapterInfo, result *BookSyncResult, account string, dryRun bool) (err error) {
+	hashes, err := getChapterHashes(db, bookID, dryRun)
+	if err != nil {
+		return
+	}
+	for index, item := range chapters {
+		no := uint(index)
+		current := hashes[no]
+		delete(hashes, no)
+		if current != nil && current.Hash == item.Hash {
+			result.Unchanged++
+			continue
+		}
+		if current == nil {
+			result.Added = append(result.Added, no)
+		} else {
+			result.Changed = append(result.Changed, no)
+		}
+		if dryRun {
+			continue
+		}
+		// 新增与更新的章节均记录revision
+		if current == nil {
+			err = createChapter(db, &Chapter{
+				BookID:  bookID,
+				NO:      no,
+				Title:   item.Title,
+				Content: item.Content,
+			}, account, chapterRevisionNoteSync)
+		} else {
+			current.BookID = bookID
+			err = updateChapterContent(db, current, item.Title, item.Content, account, chapterRevisionNoteSync)
+		}
+		if err != nil {
+			return
+		}
+	}
+	// 剩余的章节为已删除
+	for no, item := range hashes {
+		result.Removed = append(result.Removed, no)
+		if dryRun {
+			continue
+		}
+		// 直接删除记录，避免软删除的记录占用book_id_no唯一索引
+		err = db.Unscoped().Delete(&Chapter{
+			ID: item.ID,
+		}).Error
 		if err != nil {
 			return
 		}
-		err = updateBookExtraInfo(book.ID)
+		err = deleteChapterComments(db, bookID, no)
 		if err != nil {
 			return
 		}
 	}
+	sort.Slice(result.Removed, func(i, j int) bool {
+		return result.Removed[i] < result.Removed[j]
+	})
 	return
 }
 
-// SyncFromFile sync from file
-func (srv *BookSrv) SyncFromFile(path string) (err error) {
-	listFile := filepath.Join(path, "books.json")
-	buf, err := ioutil.ReadFile(listFile)
+// syncBook sync book and its chapters
+func syncBook(path string, item *syncBookInfo, account string, dryRun bool) (result *BookSyncResult, err error) {
+	chapters, err := readSyncChapters(path, item.Name)
 	if err != nil {
 		return
 	}
-	books := make([]*struct {
-		Name   string
-		Author string
-	}, 0)
-	err = json.Unmarshal(buf, &books)
-	if err != nil {
-		return
+	result = &BookSyncResult{
+		Name:   item.Name,
+		Author: item.Author,
 	}
-
-	for _, item := range books {
-		book := Book{}
+	book := Book{}
+	if dryRun {
 		err = pgGetClient().Where(Book{
 			Name:   item.Name,
 			Author: item.Author,
-		}).FirstOrCreate(&book).Error
+		}).First(&book).Error
+		if gorm.IsRecordNotFoundError(err) {
+			err = nil
+		}
 		if err != nil {
 			return
 		}
-		err = updateChapters(path, book)
+		result.BookID = book.ID
+		err = syncChapters(pgGetClient(), book.ID, chapters, result, account, true)
+		return
+	}
+
+	tx := pgGetClient().Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	err = tx.Where(Book{
+		Name:   item.Name,
+		Author: item.Author,
+	}).FirstOrCreate(&book).Error
+	if err != nil {
+		return
+	}
+	result.BookID = book.ID
+	err = syncChapters(tx, book.ID, chapters, result, account, false)
+	if err != nil {
+		return
+	}
+	// 有更新时才需要重新计算书籍信息
+	if result.HasChanged() {
+		err = updateBookExtraInfo(tx, book.ID)
 		if err != nil {
 			return
 		}
 	}
+	err = tx.Commit().Error
+	return
+}
+
+// HasChanged check the book has changed chapters
+func (result *BookSyncResult) HasChanged() bool {
+	return len(result.Added) != 0 ||
+		len(result.Changed) != 0 ||
+		len(result.Removed) != 0
+}
+
+// SyncFromFile sync from file, if dry run is true, it only returns the diff of chapters.
+// The sync will be stopped before the next book when the context is done.
+func (srv *BookSrv) SyncFromFile(ctx context.Context, params BookSyncParams) (results []*BookSyncResult, err error) {
+	path := params.Path
+	// 先校验所有文件，避免同步到一半才出错
+	err = validateSyncDir(path)
+	if err != nil {
+		return
+	}
+	books, err := readSyncBooks(path)
+	if err != nil {
+		return
+	}
+	total := len(books)
+	if params.OnProgress != nil {
+		params.OnProgress(0, total, nil)
+	}
+	results = make([]*BookSyncResult, 0, total)
+	for index, item := range books {
+		select {
+		case <-ctx.Done():
+			return results, ctx.Err()
+		default:
+		}
+		result, err := syncBook(path, item, params.Account, params.DryRun)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+		if params.OnProgress != nil {
+			params.OnProgress(index+1, total, result)
+		}
+	}
 	return
 }
 
 func newBookQuery(params BookQueryParams) *gorm.DB {
 	db := pgGetClient()
 	if params.Keyword != "" {
-		db = db.Where("name LIKE ?", "%"+params.Keyword+"%")
+		// 数据为简体，繁体的关键字需要先转换
+		db = db.Where("name LIKE ?", "%"+NormalizeKeyword(params.Keyword)+"%")
+	}
+	if !params.Unpublished {
+		db = db.Where("books.status = ?", cs.PublishPublished)
 	}
+	db = filterBookByTags(db, params.Tags, params.TagMode)
 	return db
 }
 
@@ -193,6 +455,12 @@ func (srv *BookSrv) UpdateByID(id uint, data interface{}) (err error) {
 	err = pgGetClient().Model(&Book{
 		ID: id,
 	}).Update(data).Error
+	if err != nil {
+		return
+	}
+	expireBookSuggestIndex()
+	// 人工设置的热度有可能调整，重新计算综合热度
+	err = refreshBookHotScore(pgGetClient(), id)
 	return
 }
 
@@ -203,14 +471,6 @@ func (srv *BookSrv) Count(params BookQueryParams) (count int, err error) {
 	return
 }
 
-// IncHotByID inc hot value by id
-func (srv *BookSrv) IncHotByID(id uint, value int) (err error) {
-	err = pgGetClient().Model(&Book{
-		ID: id,
-	}).UpdateColumn("hot", gorm.Expr("hot + ?", value)).Error
-	return
-}
-
 // List list book
 func (srv *BookSrv) List(params BookQueryParams) (result []*Book, err error) {
 	result = make([]*Book, 0)
@@ -244,9 +504,21 @@ func newChapterQuery(params ChapterQueryParams) *gorm.DB {
 	if params.BookID != 0 {
 		db = db.Where("book_id = ?", params.BookID)
 	}
+	if params.FromNO != 0 {
+		db = db.Where("no >= ?", params.FromNO)
+	}
+	if !params.Unpublished {
+		db = db.Where("status = ?", cs.PublishPublished)
+	}
 	return db
 }
 
+// CountChapter count chapter
+func (srv *BookSrv) CountChapter(params ChapterQueryParams) (count int, err error) {
+	err = newChapterQuery(params).Model(&Chapter{}).Count(&count).Error
+	return
+}
+
 // ListChapter list chapter
 func (srv *BookSrv) ListChapter(params ChapterQueryParams) (result []*Chapter, err error) {
 	result = make([]*Chapter, 0)
@@ -279,19 +551,69 @@ func (srv *BookSrv) GetByID(id uint) (book *Book, err error) {
 	return
 }
 
-// UpdateHot update the hot value of book
-func (srv *BookSrv) UpdateHot() (err error) {
-	result := make([]*Book, 0)
-	err = pgGetClient().Find(&result).Error
-	for _, book := range result {
-		if book.Hot == 0 && book.Summary != "" {
-			err = srv.UpdateByID(book.ID, Book{
-				Hot: 1,
-			})
-			if err != nil {
-				return
-			}
-		}
+// GetPublishedByID get the published book by id
+func (srv *BookSrv) GetPublishedByID(id uint) (book *Book, err error) {
+	book = &Book{}
+	err = pgGetClient().
+		Where("id = ? AND status = ?", id, cs.PublishPublished).
+		First(book).Error
+	return
+}
+
+// GetPublishedChapter get the published chapter of published book by no
+func (srv *BookSrv) GetPublishedChapter(bookID, no uint) (chapter *Chapter, err error) {
+	_, err = srv.GetPublishedByID(bookID)
+	if err != nil {
+		return
+	}
+	chapter = &Chapter{}
+	err = pgGetClient().
+		Where("book_id = ? AND no = ? AND status = ?", bookID, no, cs.PublishPublished).
+		First(chapter).Error
+	return
+}
+
+// GetChapter get chapter of book by no
+func (srv *BookSrv) GetChapter(bookID, no uint) (chapter *Chapter, err error) {
+	chapter = &Chapter{}
+	err = pgGetClient().
+		Where("book_id = ? AND no = ?", bookID, no).
+		First(chapter).Error
+	return
+}
+
+// GetAdjacentChapters get the previous and next chapter(only no and title) of the no,
+// it is nil if there is no previous or next chapter
+func (srv *BookSrv) GetAdjacentChapters(bookID, no uint, unpublished bool) (prev, next *Chapter, err error) {
+	params := ChapterQueryParams{
+		BookID:      bookID,
+		Unpublished: unpublished,
+	}
+	chapters := make([]*Chapter, 0)
+	err = newChapterQuery(params).
+		Select("no, title").
+		Where("no < ?", no).
+		Order("no desc").
+		Limit(1).
+		Find(&chapters).Error
+	if err != nil {
+		return
+	}
+	if len(chapters) != 0 {
+		prev = chapters[0]
+	}
+	chapters = make([]*Chapter, 0)
+	err = newChapterQuery(params).
+		Select("no, title").
+		Where("no > ?", no).
+		Order("no").
+		Limit(1).
+		Find(&chapters).Error
+	if err != nil {
+		return
+	}
+	if len(chapters) != 0 {
+		next = chapters[0]
 	}
 	return
 }
@@ -0,0 +1,281 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vicanso/hes"
+)
+
+const (
+	// 解压后的文件总大小限制
+	maxBookArchiveSize = 512 * 1024 * 1024
+	// 解压后的文件数量限制
+	maxBookArchiveFiles = 100000
+
+	bookArchiveErrCategory = "book-archive"
+)
+
+var (
+	errBookArchiveTypeInvalid = &hes.Error{
+		StatusCode: http.StatusBadRequest,
+		Message:    "book archive should be zip or tar.gz",
+		Category:   bookArchiveErrCategory,
+	}
+	errBookArchiveTooLarge = &hes.Error{
+		StatusCode: http.StatusBadRequest,
+		Message:    "book archive is too large",
+		Category:   bookArchiveErrCategory,
+	}
+	errBookArchiveFileInvalid = errors.New("file path of archive is invalid")
+	errSyncBookNameInvalid    = errors.New("book name should not contain path characters")
+)
+
+type (
+	// bookArchiveExtractor extract the files of archive to the directory
+	bookArchiveExtractor struct {
+		dir   string
+		size  int64
+		count int
+	}
+)
+
+// write write the file of archive to the directory
+func (e *bookArchiveExtractor) write(name string, r io.Reader) (err error) {
+	name = filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return errBookArchiveFileInvalid
+	}
+	e.count++
+	if e.count > maxBookArchiveFiles {
+		return errBookArchiveTooLarge
+	}
+	file := filepath.Join(e.dir, name)
+	err = os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	// 多读取一个字节用于判断是否超出限制
+	limit := maxBookArchiveSize - e.size
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		return
+	}
+	if n > limit {
+		return errBookArchiveTooLarge
+	}
+	e.size += n
+	return
+}
+
+func (e *bookArchiveExtractor) extractZip(r io.ReaderAt, size int64) (err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	for _, item := range zr.File {
+		if item.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := item.Open()
+		if err != nil {
+			return err
+		}
+		err = e.write(item.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (e *bookArchiveExtractor) extractTarGz(r io.Reader) (err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// 只处理普通文件，忽略目录与链接等
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = e.write(header.Name, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// findSyncRoot find the directory which contains books.json,
+// the archive may be packed with a top directory
+func findSyncRoot(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "books.json")); err == nil {
+		return dir
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) == 1 && files[0].IsDir() {
+		return filepath.Join(dir, files[0].Name())
+	}
+	return dir
+}
+
+// validateSyncDir validate the layout of sync directory,
+// the field of error is the relative path of file
+func validateSyncDir(path string) (err error) {
+	fields := make(map[string]string)
+	books, e := readSyncBooks(path)
+	if e != nil {
+		fields["books.json"] = e.Error()
+	}
+	if e == nil && len(books) == 0 {
+		fields["books.json"] = "book list can't be empty"
+	}
+	names := make(map[string]bool)
+	for index, item := range books {
+		field := fmt.Sprintf("books.json[%d]", index)
+		if item.Name == "" || utf8.RuneCountInString(item.Name) > 100 {
+			fields[field+".name"] = "name should be 1-100 characters"
+			continue
+		}
+		if item.Author == "" || utf8.RuneCountInString(item.Author) > 40 {
+			fields[field+".author"] = "author should be 1-40 characters"
+		}
+		if names[item.Name] {
+			fields[field+".name"] = "name is duplicated"
+			continue
+		}
+		names[item.Name] = true
+		// 书名作为目录名，不允许包含路径字符，避免读取同步目录之外的文件
+		dir, e := getSyncBookDir(path, item.Name)
+		if e != nil {
+			fields[field+".name"] = e.Error()
+			continue
+		}
+
+		chaptersFile := item.Name + "/chapters.json"
+		buf, e := ioutil.ReadFile(filepath.Join(dir, "chapters.json"))
+		if e != nil {
+			fields[chaptersFile] = "chapters.json is not found"
+			continue
+		}
+		chapters := make([]*syncChapterInfo, 0)
+		e = json.Unmarshal(buf, &chapters)
+		if e != nil {
+			fields[chaptersFile] = e.Error()
+			continue
+		}
+		for no, chapter := range chapters {
+			if chapter.Title == "" {
+				fields[fmt.Sprintf("%s[%d].title", chaptersFile, no)] = "title can't be empty"
+			}
+			name := fmt.Sprintf("chapter-%d.txt", no)
+			buf, e := ioutil.ReadFile(filepath.Join(dir, name))
+			if e != nil {
+				fields[item.Name+"/"+name] = "chapter file is not found"
+				continue
+			}
+			if !utf8.Valid(buf) {
+				fields[item.Name+"/"+name] = "chapter file should be utf-8"
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	err = &hes.Error{
+		StatusCode: http.StatusBadRequest,
+		Message:    "book sync files are invalid",
+		Category:   bookArchiveErrCategory,
+		Extra: map[string]interface{}{
+			"fields": fields,
+		},
+	}
+	return
+}
+
+// ExtractBookArchive extract the archive(zip or tar.gz) and validate its layout,
+// the layout of archive is the same as SyncFromFile.
+// It returns the directory of archive(should be removed after sync) and the sync path.
+func ExtractBookArchive(name string, r io.ReaderAt, size int64) (dir, path string, err error) {
+	err = os.MkdirAll(cacheDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	dir, err = ioutil.TempDir(cacheDir, "book-archive-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+			dir = ""
+		}
+	}()
+	e := &bookArchiveExtractor{
+		dir: dir,
+	}
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		err = e.extractZip(r, size)
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		err = e.extractTarGz(io.NewSectionReader(r, 0, size))
+	default:
+		err = errBookArchiveTypeInvalid
+	}
+	if err != nil {
+		// 解压失败的均认为是文件有误
+		if _, ok := err.(*hes.Error); !ok {
+			err = &hes.Error{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+				Category:   bookArchiveErrCategory,
+				Err:        err,
+			}
+		}
+		return
+	}
+	path = findSyncRoot(dir)
+	err = validateSyncDir(path)
+	return
+}
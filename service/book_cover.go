@@ -0,0 +1,439 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/golang/freetype/truetype"
+	"github.com/llgcode/draw2d"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+	"github.com/mozillazg/go-pinyin"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/config"
+	"github.com/vicanso/wsl/cs"
+	"go.uber.org/zap"
+)
+
+const (
+	// BookCoverKindCover the cover of book
+	BookCoverKindCover = "cover"
+	// BookCoverKindOG the open graph share image of book
+	BookCoverKindOG = "og"
+
+	defaultCoverFont  = "DroidSansFallbackFull.ttf"
+	defaultCoverColor = "#ffffff"
+	// 配置的字体加载失败时使用的字体（font目录自带，不支持中文）
+	fallbackCoverFont = "luxisr.ttf"
+	// 配置的字体加载失败后的重试间隔
+	coverFontRetryInterval = time.Minute
+)
+
+type (
+	// bookCoverLayout the layout of generated image
+	bookCoverLayout struct {
+		Width  int
+		Height int
+		// 书名每行的最大字数
+		LineRunes int
+	}
+	// coverFont the font of cover
+	coverFont struct {
+		file string
+		font *truetype.Font
+		// 每个字体文件注册为不同的字体，避免切换字体时影响正在生成的图片
+		data draw2d.FontData
+	}
+)
+
+var (
+	errBookCoverFontInvalid = &hes.Error{
+		StatusCode: http.StatusInternalServerError,
+		Message:    "the font of book cover is invalid",
+	}
+
+	coverFontMutex   = new(sync.Mutex)
+	currentCoverFont *coverFont
+	// 配置的字体加载失败的时间
+	coverFontFailedAt time.Time
+
+	coverMutex = new(sync.Mutex)
+
+	// 默认的背景色
+	defaultCoverBackgrounds = []string{
+		"#2c3e50",
+		"#8e44ad",
+		"#16a085",
+		"#c0392b",
+		"#d35400",
+		"#2980b9",
+		"#27ae60",
+		"#7f8c8d",
+	}
+)
+
+// getBookCoverLayout get the layout of kind
+func getBookCoverLayout(kind string) *bookCoverLayout {
+	if kind == BookCoverKindOG {
+		return &bookCoverLayout{
+			Width:     config.GetIntDefault("cover.og.width", 1200),
+			Height:    config.GetIntDefault("cover.og.height", 630),
+			LineRunes: 12,
+		}
+	}
+	return &bookCoverLayout{
+		Width:     config.GetIntDefault("cover.width", 600),
+		Height:    config.GetIntDefault("cover.height", 800),
+		LineRunes: 6,
+	}
+}
+
+// parseHexColor parse the color(#rrggbb), the default color is returned if it is invalid
+func parseHexColor(value string, defaultColor color.RGBA) color.RGBA {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) != 6 {
+		return defaultColor
+	}
+	v, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return defaultColor
+	}
+	return color.RGBA{
+		R: uint8(v >> 16),
+		G: uint8(v >> 8),
+		B: uint8(v),
+		A: 255,
+	}
+}
+
+// getCoverBackground get the background of book, the same name always gets the same color
+func getCoverBackground(name string) color.RGBA {
+	backgrounds := config.GetStringSlice("cover.backgrounds")
+	if len(backgrounds) == 0 {
+		backgrounds = defaultCoverBackgrounds
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return parseHexColor(backgrounds[h.Sum32()%uint32(len(backgrounds))], color.RGBA{44, 62, 80, 255})
+}
+
+// getCoverFontFile get the file of font
+func getCoverFontFile(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(fontPath, name)
+}
+
+// loadCoverFont load the font from file
+func loadCoverFont(file string) (font *coverFont, err error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	f, err := truetype.Parse(buf)
+	if err != nil {
+		return
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(file))
+	font = &coverFont{
+		file: file,
+		font: f,
+		data: draw2d.FontData{
+			Name:   fmt.Sprintf("bookcover%x", h.Sum32()),
+			Family: draw2d.FontFamilySans,
+			Style:  draw2d.FontStyleNormal,
+		},
+	}
+	draw2d.RegisterFont(font.data, font.font)
+	return
+}
+
+// getCoverFont get the font(should support chinese) of cover,
+// the fallback font is used if the configured font is invalid, and it will be reloaded after a while
+func getCoverFont() (font *coverFont, err error) {
+	coverFontMutex.Lock()
+	defer coverFontMutex.Unlock()
+	file := getCoverFontFile(config.GetStringDefault("cover.font", defaultCoverFont))
+	if currentCoverFont != nil &&
+		(currentCoverFont.file == file || time.Since(coverFontFailedAt) < coverFontRetryInterval) {
+		return currentCoverFont, nil
+	}
+	font, err = loadCoverFont(file)
+	if err != nil {
+		logger.Error("load cover font fail, use fallback font",
+			zap.String("file", file),
+			zap.Error(err),
+		)
+		coverFontFailedAt = time.Now()
+		fallbackFile := getCoverFontFile(fallbackCoverFont)
+		// 已是使用fallback的字体则无需重新加载
+		if currentCoverFont != nil && currentCoverFont.file == fallbackFile {
+			return currentCoverFont, nil
+		}
+		font, err = loadCoverFont(fallbackFile)
+		if err != nil {
+			logger.Error("load fallback cover font fail",
+				zap.String("file", fallbackFile),
+				zap.Error(err),
+			)
+			err = errBookCoverFontInvalid
+			return
+		}
+	}
+	currentCoverFont = font
+	return
+}
+
+// getCoverText get the text which can be drawn by the font,
+// the chinese which is not supported by font is converted to pinyin
+func getCoverText(font *coverFont, text string) string {
+	b := new(strings.Builder)
+	lastIsPinyin := false
+	for _, r := range strings.TrimSpace(text) {
+		if font.font.Index(r) != 0 || !unicode.Is(unicode.Han, r) {
+			if lastIsPinyin && !unicode.IsSpace(r) {
+				b.WriteRune(' ')
+			}
+			b.WriteRune(r)
+			lastIsPinyin = false
+			continue
+		}
+		pys := pinyin.SinglePinyin(r, pinyinArgs)
+		if len(pys) == 0 || pys[0] == "" {
+			continue
+		}
+		if b.Len() != 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteString(strings.ToUpper(pys[0][:1]) + pys[0][1:])
+		lastIsPinyin = true
+	}
+	return b.String()
+}
+
+// splitCoverLines split the text to lines by max runes of each line,
+// the text with spaces(e.g. pinyin) is split by words
+func splitCoverLines(text string, max int) []string {
+	lines := make([]string, 0)
+	words := strings.Fields(text)
+	if len(words) > 1 {
+		// 字母的宽度约为中文的一半
+		max *= 2
+		line := ""
+		for _, word := range words {
+			if line != "" && len([]rune(line))+1+len([]rune(word)) > max {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		return lines
+	}
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > max {
+		lines = append(lines, string(runes[:max]))
+		runes = runes[max:]
+	}
+	if len(runes) != 0 {
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+// fillCenterString fill the string at the horizontal center, y is the baseline
+func fillCenterString(gc *draw2dimg.GraphicContext, text string, width, y float64) {
+	left, _, right, _ := gc.GetStringBounds(text)
+	gc.FillStringAt(text, (width-(right-left))/2-left, y)
+}
+
+// createBookCover draw the image of book with name and author
+func createBookCover(font *coverFont, name, author string, layout *bookCoverLayout) (img *image.RGBA, err error) {
+	width := float64(layout.Width)
+	height := float64(layout.Height)
+	img = image.NewRGBA(image.Rect(0, 0, layout.Width, layout.Height))
+	gc := draw2dimg.NewGraphicContext(img)
+
+	// 背景
+	gc.SetFillColor(getCoverBackground(name))
+	draw2dkit.Rectangle(gc, 0, 0, width, height)
+	gc.Fill()
+
+	textColor := parseHexColor(config.GetStringDefault("cover.color", defaultCoverColor), color.RGBA{255, 255, 255, 255})
+	// 内边框
+	padding := width / 16
+	gc.SetStrokeColor(color.NRGBA{textColor.R, textColor.G, textColor.B, 120})
+	gc.SetLineWidth(width / 200)
+	draw2dkit.Rectangle(gc, padding, padding, width-padding, height-padding)
+	gc.Stroke()
+
+	gc.SetFontData(font.data)
+	gc.SetFillColor(textColor)
+
+	// 书名，字体大小按每行字数计算（字体大小单位为pt）
+	lines := splitCoverLines(getCoverText(font, name), layout.LineRunes)
+	if len(lines) == 0 {
+		return
+	}
+	maxWidth := width - 4*padding
+	fontSize := maxWidth / float64(layout.LineRunes) * 72 / float64(gc.GetDPI())
+	gc.SetFontSize(fontSize)
+	// 超出宽度时缩小字体
+	for _, line := range lines {
+		left, _, right, _ := gc.GetStringBounds(line)
+		if right-left > maxWidth {
+			fontSize = fontSize * maxWidth / (right - left)
+			gc.SetFontSize(fontSize)
+		}
+	}
+	lineHeight := fontSize * float64(gc.GetDPI()) / 72 * 1.4
+	y := (height-lineHeight*float64(len(lines)))/2 + lineHeight*0.8
+	for _, line := range lines {
+		fillCenterString(gc, line, width, y)
+		y += lineHeight
+	}
+
+	// 作者
+	if author != "" {
+		gc.SetFontSize(fontSize / 2)
+		fillCenterString(gc, getCoverText(font, author), width, height-2*padding)
+	}
+	return
+}
+
+// getBookCoverFile get the cache file of generated image,
+// the name of file is changed when the name, author, style or s2t dict is changed
+func getBookCoverFile(book *Book, kind, lang string, font *coverFont, layout *bookCoverLayout) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.Join([]string{
+		book.Name,
+		book.Author,
+		// 使用的字体（配置的字体加载失败时为fallback字体）
+		font.file,
+		// 简繁转换的词组覆盖
+		GetS2TDictVersion(),
+		config.GetStringDefault("cover.color", defaultCoverColor),
+		strings.Join(config.GetStringSlice("cover.backgrounds"), ","),
+		strconv.Itoa(layout.Width),
+		strconv.Itoa(layout.Height),
+	}, "\n")))
+	name := fmt.Sprintf("book-%d-%s-%s-%x.png", book.ID, kind, lang, h.Sum64())
+	return filepath.Join(cacheDir, "cover", name)
+}
+
+// removeExpiredBookCover remove the expired generated image of book
+func removeExpiredBookCover(book *Book, kind, lang, current string) {
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "cover", fmt.Sprintf("book-%d-%s-%s-*.png", book.ID, kind, lang)))
+	for _, file := range files {
+		if file != current {
+			os.Remove(file)
+		}
+	}
+}
+
+// getBookCoverImage get the generated image(png) of book, it is cached on disk
+func getBookCoverImage(book *Book, kind, lang string) (buf []byte, err error) {
+	if !IsTraditionalLang(lang) {
+		lang = cs.LangSC
+	}
+	font, err := getCoverFont()
+	if err != nil {
+		return
+	}
+	layout := getBookCoverLayout(kind)
+	file := getBookCoverFile(book, kind, lang, font, layout)
+	buf, err = ioutil.ReadFile(file)
+	if err == nil {
+		return
+	}
+
+	coverMutex.Lock()
+	defer coverMutex.Unlock()
+	// 有可能在等待锁时已生成
+	buf, err = ioutil.ReadFile(file)
+	if err == nil {
+		return
+	}
+
+	name, err := ConvertLang(lang, book.Name)
+	if err != nil {
+		return
+	}
+	author, err := ConvertLang(lang, book.Author)
+	if err != nil {
+		return
+	}
+	img, err := createBookCover(font, name, author, layout)
+	if err != nil {
+		return
+	}
+	buffer := new(bytes.Buffer)
+	err = png.Encode(buffer, img)
+	if err != nil {
+		return
+	}
+	buf = buffer.Bytes()
+
+	// 写入缓存失败则只输出日志
+	e := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if e == nil {
+		tmpFile := file + ".tmp"
+		e = ioutil.WriteFile(tmpFile, buf, 0600)
+		if e == nil {
+			e = os.Rename(tmpFile, file)
+		}
+	}
+	if e != nil {
+		logger.Error("write book cover cache fail",
+			zap.String("file", file),
+			zap.Error(e),
+		)
+		return
+	}
+	removeExpiredBookCover(book, kind, lang, file)
+	return
+}
+
+// GetGeneratedCover get the generated image of book(cover or open graph share image)
+func (srv *BookSrv) GetGeneratedCover(id uint, kind, lang string) (buf []byte, book *Book, err error) {
+	book, err = srv.GetPublishedByID(id)
+	if err != nil {
+		return
+	}
+	buf, err = getBookCoverImage(book, kind, lang)
+	return
+}
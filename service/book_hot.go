@@ -0,0 +1,176 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/wsl/config"
+	"github.com/vicanso/wsl/util"
+	"go.uber.org/zap"
+)
+
+const (
+	// 缓存书籍浏览次数的hash，定时批量写入数据库
+	bookViewsKey = "book-views"
+
+	bookHotDecayLockKey = "book-hot-decay"
+	// 最近一次热度衰减的时间
+	bookHotDecayAtKey = "book-hot-decay-at"
+	// 旧版本的hot为浏览次数，迁移至view_score后设置（仅迁移一次）
+	bookHotMigratedKey = "book-hot-migrated"
+
+	// 人工设置热度的最大值（与xBookHot一致）
+	maxBookHot = 100
+)
+
+var (
+	// 浏览热度的半衰期
+	bookHotHalfLife time.Duration
+	// 人工设置热度的权重
+	bookHotBoost int
+)
+
+func init() {
+	bookHotHalfLife = config.GetDurationDefault("book.hotHalfLife", 7*24*time.Hour)
+	bookHotBoost = config.GetIntDefault("book.hotBoost", 10)
+	initBookHot()
+}
+
+// initBookHot 迁移旧版本的热度数据，并重新计算所有书籍的综合热度
+func initBookHot() {
+	err := migrateBookHot()
+	if err != nil {
+		logger.Error("migrate book hot fail",
+			zap.Error(err),
+		)
+	}
+	// 综合热度根据当前配置的权重计算（首次衰减前也可按热度排序）
+	err = pgGetClient().Exec(`UPDATE books SET
+		hot_score = view_score + LEAST(hot, ?) * ?`,
+		maxBookHot, bookHotBoost,
+	).Error
+	if err != nil {
+		logger.Error("refresh book hot score fail",
+			zap.Error(err),
+		)
+	}
+}
+
+// migrateBookHot 旧版本的hot为浏览时累加的次数，将其迁移为浏览热度，
+// 由于无法区分其中人工设置的热度，hot重置为0，需要重新设置
+func migrateBookHot() (err error) {
+	// 多实例时只有一个实例执行，迁移成功后不再执行
+	success, err := redisSrv.Lock(bookHotMigratedKey, 0)
+	if err != nil || !success {
+		return
+	}
+	err = pgGetClient().Exec(`UPDATE books SET
+		view_score = view_score + hot,
+		hot = 0
+		WHERE hot != 0`,
+	).Error
+	if err != nil {
+		// 迁移失败则删除标记，下次启动时重试
+		_ = redisSrv.Del(bookHotMigratedKey)
+	}
+	return
+}
+
+// refreshBookHotScore 根据浏览热度与人工设置的热度重新计算综合热度
+func refreshBookHotScore(db *gorm.DB, id uint) error {
+	return db.Exec(`UPDATE books SET
+		hot_score = view_score + LEAST(hot, ?) * ?
+		WHERE id = ?`,
+		maxBookHot, bookHotBoost, id,
+	).Error
+}
+
+// AddView add the view count of book, it is cached in redis until flush
+func (srv *BookSrv) AddView(id uint) (err error) {
+	err = redisSrv.HIncrBy(bookViewsKey, strconv.Itoa(int(id)), 1)
+	return
+}
+
+// FlushViews flush the cached view count to book's view score
+func (srv *BookSrv) FlushViews() (err error) {
+	views, err := redisSrv.HGetAllAndDel(bookViewsKey)
+	if err != nil || len(views) == 0 {
+		return
+	}
+	for key, value := range views {
+		id, _ := strconv.Atoi(key)
+		count, _ := strconv.Atoi(value)
+		if id <= 0 || count <= 0 {
+			continue
+		}
+		e := pgGetClient().Exec(`UPDATE books SET
+			view_score = view_score + ?,
+			hot_score = view_score + ? + LEAST(hot, ?) * ?
+			WHERE id = ?`,
+			count, count, maxBookHot, bookHotBoost, id,
+		).Error
+		if e != nil {
+			err = e
+			// 写入失败则重新缓存，等待下次写入
+			redisSrv.HIncrBy(bookViewsKey, key, int64(count))
+			logger.Error("flush book views fail",
+				zap.Int("id", id),
+				zap.Int("count", count),
+				zap.Error(e),
+			)
+		}
+	}
+	return
+}
+
+// UpdateHot decay the view score of books by the half life
+func (srv *BookSrv) UpdateHot() (err error) {
+	// 多实例时只有一个实例执行
+	success, done, err := redisSrv.LockWithDone(bookHotDecayLockKey, time.Minute)
+	if err != nil || !success {
+		return
+	}
+	defer done()
+	now := util.Now()
+	value, err := redisSrv.Get(bookHotDecayAtKey)
+	if err != nil {
+		return
+	}
+	last, _ := strconv.ParseInt(value, 10, 64)
+	// 首次执行只记录时间
+	if last == 0 {
+		err = redisSrv.Set(bookHotDecayAtKey, now.Unix(), 0)
+		return
+	}
+	elapsed := now.Sub(time.Unix(last, 0))
+	if elapsed < time.Minute {
+		return
+	}
+	factor := math.Pow(0.5, elapsed.Seconds()/bookHotHalfLife.Seconds())
+	err = pgGetClient().Exec(`UPDATE books SET
+		view_score = view_score * ?,
+		hot_score = view_score * ? + LEAST(hot, ?) * ?`,
+		factor, factor, maxBookHot, bookHotBoost,
+	).Error
+	if err != nil {
+		return
+	}
+	err = redisSrv.Set(bookHotDecayAtKey, now.Unix(), 0)
+	return
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"html"
+	"time"
+
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	defaultBookReviewLimit = 10
+)
+
+type (
+	// BookReview the rating and review of book, each account has only one review of book
+	BookReview struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		BookID  uint   `json:"bookID,omitempty" gorm:"not null;unique_index:idx_book_reviews_book_account"`
+		Account string `json:"account,omitempty" gorm:"type:varchar(20);not null;unique_index:idx_book_reviews_book_account"`
+		// 评分(1-5)
+		Score   int    `json:"score,omitempty" gorm:"not null"`
+		Content string `json:"content,omitempty" gorm:"type:varchar(2000)"`
+	}
+	// BookReviewQueryParams book review query params
+	BookReviewQueryParams struct {
+		BookID uint
+		Offset int
+		Limit  int
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&BookReview{})
+}
+
+// UpsertReview add or update the review of book,
+// the rating of book will be updated in the same transaction
+func (srv *BookSrv) UpsertReview(review *BookReview) (err error) {
+	tx := pgGetClient().Begin()
+	// 锁定书籍记录，避免并发更新时评分统计有误
+	book := &Book{}
+	err = tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id").
+		Where("id = ? AND status = ?", review.BookID, cs.PublishPublished).
+		First(book).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	now := util.Now()
+	err = tx.Exec(`INSERT INTO book_reviews
+		(created_at, updated_at, book_id, account, score, content)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (book_id, account) DO UPDATE SET
+			updated_at = EXCLUDED.updated_at,
+			score = EXCLUDED.score,
+			content = EXCLUDED.content,
+			deleted_at = NULL`,
+		now, now, review.BookID, review.Account, review.Score, review.Content,
+	).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Exec(`UPDATE books SET
+		rating = COALESCE(stats.avg, 0),
+		rating_count = stats.count
+		FROM (
+			SELECT AVG(score) AS avg, COUNT(*) AS count
+			FROM book_reviews
+			WHERE book_id = ? AND deleted_at IS NULL
+		) AS stats
+		WHERE books.id = ?`,
+		review.BookID, review.BookID,
+	).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit().Error
+	return
+}
+
+// GetReview get the review of account
+func (srv *BookSrv) GetReview(bookID uint, account string) (review *BookReview, err error) {
+	review = &BookReview{}
+	err = pgGetClient().
+		Where("book_id = ? AND account = ?", bookID, account).
+		First(review).Error
+	if err != nil {
+		return
+	}
+	review.Content = html.EscapeString(review.Content)
+	return
+}
+
+// ListReview list the reviews of book, the latest updated ones are first
+func (srv *BookSrv) ListReview(params BookReviewQueryParams) (result []*BookReview, err error) {
+	result = make([]*BookReview, 0)
+	db := pgGetClient().Where("book_id = ?", params.BookID)
+	if params.Limit <= 0 {
+		db = db.Limit(defaultBookReviewLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	if params.Offset > 0 {
+		db = db.Offset(params.Offset)
+	}
+	err = db.Order("updated_at desc, id desc").Find(&result).Error
+	if err != nil {
+		return
+	}
+	for _, item := range result {
+		item.Content = html.EscapeString(item.Content)
+	}
+	return
+}
+
+// CountReview count the reviews of book
+func (srv *BookSrv) CountReview(params BookReviewQueryParams) (count int, err error) {
+	err = pgGetClient().
+		Model(&BookReview{}).
+		Where("book_id = ?", params.BookID).
+		Count(&count).Error
+	return
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/wsl/config"
+	"github.com/vicanso/wsl/cs"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit = 10
+	// 默认使用zhparser中文分词
+	defaultSearchConfig = "chinese"
+
+	// 高亮使用不会出现在章节内容中的控制字符，转义后再替换为标签
+	searchHighlightStart = "\x01"
+	searchHighlightStop  = "\x02"
+	// 简单的分词配置（不依赖扩展）
+	simpleSearchConfig = "simple"
+)
+
+var (
+	// 全文检索配置，如chinese(zhparser)或simple
+	searchConfig = defaultSearchConfig
+	// 章节全文检索的tsvector表达式，查询时需要与索引的表达式完全一致
+	// （books表无title与content字段，因此联表查询时不需要指定表名）
+	chapterSearchVector string
+
+	searchConfigReg = regexp.MustCompile(`^[a-z_]+$`)
+)
+
+type (
+	// BookSearchParams book search params
+	BookSearchParams struct {
+		Keyword string
+		Offset  int
+		Limit   int
+	}
+	// BookSearchResult book search result
+	BookSearchResult struct {
+		BookID       uint    `json:"bookID,omitempty"`
+		BookName     string  `json:"bookName,omitempty"`
+		BookAuthor   string  `json:"bookAuthor,omitempty"`
+		NO           uint    `json:"no"`
+		ChapterTitle string  `json:"chapterTitle,omitempty"`
+		Snippet      string  `json:"snippet,omitempty"`
+		Rank         float64 `json:"rank,omitempty"`
+	}
+)
+
+func init() {
+	value := config.GetStringDefault("search.config", defaultSearchConfig)
+	if !searchConfigReg.MatchString(value) {
+		panic("search config is invalid: " + value)
+	}
+	setSearchConfig(value)
+	initChapterSearchIndex()
+}
+
+// setSearchConfig set the search config and the tsvector expression of chapter
+func setSearchConfig(value string) {
+	searchConfig = value
+	chapterSearchVector = fmt.Sprintf("to_tsvector('%s', coalesce(title, '') || ' ' || coalesce(content, ''))", searchConfig)
+}
+
+// initChapterSearchIndex 初始化中文分词配置与章节的全文索引
+func initChapterSearchIndex() {
+	db := pgGetClient()
+	if searchConfig == defaultSearchConfig {
+		// 需要数据库已安装zhparser，失败时使用simple配置（不支持中文分词）
+		err := db.Exec(`CREATE EXTENSION IF NOT EXISTS zhparser`).Error
+		if err == nil {
+			err = db.Exec(fmt.Sprintf(`DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = '%s') THEN
+					CREATE TEXT SEARCH CONFIGURATION %s (PARSER = zhparser);
+					ALTER TEXT SEARCH CONFIGURATION %s ADD MAPPING FOR n,v,a,i,e,l WITH simple;
+				END IF;
+			END
+			$$`, searchConfig, searchConfig, searchConfig)).Error
+		}
+		if err != nil {
+			logger.Error("init zhparser fail, use simple search config instead",
+				zap.Error(err),
+			)
+			setSearchConfig(simpleSearchConfig)
+		}
+	}
+	// 表达式索引由数据库在写入章节时自动维护，因此同步章节后索引即时生效
+	// （索引名称包括分词配置，配置调整后使用对应的索引）
+	err := db.Exec(fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS idx_chapters_search_%s ON chapters USING GIN (%s)`,
+		searchConfig,
+		chapterSearchVector,
+	)).Error
+	if err != nil {
+		logger.Error("create chapter search index fail",
+			zap.Error(err),
+		)
+	}
+}
+
+func newBookSearchQuery(params BookSearchParams) *gorm.DB {
+	return pgGetClient().
+		Table("chapters").
+		Joins("JOIN books ON books.id = chapters.book_id AND books.deleted_at IS NULL AND books.status = ?", cs.PublishPublished).
+		Where("chapters.deleted_at IS NULL AND chapters.status = ?", cs.PublishPublished).
+		Where(chapterSearchVector+" @@ plainto_tsquery(?, ?)", searchConfig, params.Keyword)
+}
+
+// formatSearchSnippet 转义摘要内容，并将高亮的标记替换为em标签
+func formatSearchSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	return strings.NewReplacer(
+		searchHighlightStart, "<em>",
+		searchHighlightStop, "</em>",
+	).Replace(snippet)
+}
+
+// Search search chapter content and title
+func (srv *BookSrv) Search(params BookSearchParams) (result []*BookSearchResult, err error) {
+	result = make([]*BookSearchResult, 0)
+	// 章节内容为简体，繁体的关键字需要先转换
+	params.Keyword = NormalizeKeyword(params.Keyword)
+	db := newBookSearchQuery(params)
+	if params.Limit <= 0 {
+		db = db.Limit(defaultSearchLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	if params.Offset > 0 {
+		db = db.Offset(params.Offset)
+	}
+	headlineOptions := fmt.Sprintf(
+		"StartSel=\"%s\", StopSel=\"%s\", MaxFragments=1, MaxWords=40, MinWords=15",
+		searchHighlightStart,
+		searchHighlightStop,
+	)
+	fields := []string{
+		"books.id AS book_id",
+		"books.name AS book_name",
+		"books.author AS book_author",
+		"chapters.no AS no",
+		"chapters.title AS chapter_title",
+		"ts_headline(?, chapters.content, plainto_tsquery(?, ?), ?) AS snippet",
+		"ts_rank(" + chapterSearchVector + ", plainto_tsquery(?, ?)) AS rank",
+	}
+	err = db.Select(
+		strings.Join(fields, ","),
+		searchConfig, searchConfig, params.Keyword, headlineOptions,
+		searchConfig, params.Keyword,
+	).
+		Order("rank desc, books.id, chapters.no").
+		Scan(&result).Error
+	if err != nil {
+		return
+	}
+	for _, item := range result {
+		item.Snippet = formatSearchSnippet(item.Snippet)
+	}
+	return
+}
+
+// CountSearch count the search result
+func (srv *BookSrv) CountSearch(params BookSearchParams) (count int, err error) {
+	params.Keyword = NormalizeKeyword(params.Keyword)
+	err = newBookSearchQuery(params).Count(&count).Error
+	return
+}
@@ -0,0 +1,244 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+	"github.com/vicanso/wsl/cs"
+)
+
+const (
+	defaultBookSuggestLimit = 10
+)
+
+type (
+	// BookSuggestion the suggestion of book name
+	BookSuggestion struct {
+		ID     uint   `json:"id,omitempty"`
+		Name   string `json:"name,omitempty"`
+		Author string `json:"author,omitempty"`
+		Hot    int    `json:"hot,omitempty"`
+	}
+	bookSuggestItem struct {
+		*BookSuggestion
+		// 书名（小写）
+		name string
+		// 全拼，如weisili
+		pinyin string
+		// 拼音首字母，如wsl
+		initials string
+		// 综合热度（浏览热度与人工设置的热度）
+		hotScore float64
+	}
+	// bookSuggestIndex the in-memory index of book names,
+	// the items are sorted by hot score
+	bookSuggestIndex struct {
+		version string
+		items   []*bookSuggestItem
+	}
+)
+
+var (
+	bookSuggestMutex = new(sync.RWMutex)
+	// 构建索引时加锁，避免并发重复构建
+	bookSuggestBuildMutex   = new(sync.Mutex)
+	currentBookSuggestIndex *bookSuggestIndex
+	// 本实例中书籍有调整时设置，下次获取时重建索引
+	bookSuggestExpired bool
+
+	pinyinArgs = pinyin.NewArgs()
+)
+
+// getBookNamePinyin get the full pinyin and initials of book name,
+// the letters and digits are kept
+func getBookNamePinyin(name string) (full, initials string) {
+	fullBuilder := new(strings.Builder)
+	initialsBuilder := new(strings.Builder)
+	for _, r := range name {
+		if unicode.Is(unicode.Han, r) {
+			pys := pinyin.SinglePinyin(r, pinyinArgs)
+			if len(pys) == 0 || pys[0] == "" {
+				continue
+			}
+			fullBuilder.WriteString(pys[0])
+			initialsBuilder.WriteByte(pys[0][0])
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			r = unicode.ToLower(r)
+			fullBuilder.WriteRune(r)
+			initialsBuilder.WriteRune(r)
+		}
+	}
+	return fullBuilder.String(), initialsBuilder.String()
+}
+
+// normalizeSuggestQuery lower and remove the spaces of query,
+// traditional chinese is converted to simplified chinese
+func normalizeSuggestQuery(q string) string {
+	q = strings.Join(strings.Fields(strings.ToLower(q)), "")
+	return NormalizeKeyword(q)
+}
+
+// match check the item is matched the query by name prefix, full pinyin prefix or initials prefix
+func (item *bookSuggestItem) match(q string) bool {
+	return strings.HasPrefix(item.name, q) ||
+		strings.HasPrefix(item.pinyin, q) ||
+		strings.HasPrefix(item.initials, q)
+}
+
+// getBookSuggestVersion get the version of published books,
+// the updated at of book is changed when it is modified,
+// and the sum of hot score is changed when it is recalculated(updated at is not changed)
+func getBookSuggestVersion() (version string, err error) {
+	result := &struct {
+		Count     int
+		UpdatedAt *time.Time
+		HotScore  float64
+	}{}
+	err = pgGetClient().Raw(`SELECT COUNT(*) AS count, MAX(updated_at) AS updated_at,
+		COALESCE(SUM(hot_score), 0) AS hot_score
+		FROM books WHERE deleted_at IS NULL AND status = ?`,
+		cs.PublishPublished,
+	).Scan(result).Error
+	if err != nil {
+		return
+	}
+	var updatedAt int64
+	if result.UpdatedAt != nil {
+		updatedAt = result.UpdatedAt.UnixNano()
+	}
+	version = fmt.Sprintf("%d-%d-%g", result.Count, updatedAt, result.HotScore)
+	return
+}
+
+// newBookSuggestIndex create the suggest index of published books
+func newBookSuggestIndex(version string) (index *bookSuggestIndex, err error) {
+	books := make([]*Book, 0)
+	err = pgGetClient().
+		Select("id, name, author, hot, hot_score").
+		Where("status = ?", cs.PublishPublished).
+		Find(&books).Error
+	if err != nil {
+		return
+	}
+	items := make([]*bookSuggestItem, len(books))
+	for i, book := range books {
+		full, initials := getBookNamePinyin(book.Name)
+		items[i] = &bookSuggestItem{
+			BookSuggestion: &BookSuggestion{
+				ID:     book.ID,
+				Name:   book.Name,
+				Author: book.Author,
+				Hot:    book.Hot,
+			},
+			name:     strings.ToLower(book.Name),
+			pinyin:   full,
+			initials: initials,
+			hotScore: book.HotScore,
+		}
+	}
+	// 综合热度高的优先，相同的按人工设置的热度，再按ID
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].hotScore != items[j].hotScore {
+			return items[i].hotScore > items[j].hotScore
+		}
+		if items[i].Hot != items[j].Hot {
+			return items[i].Hot > items[j].Hot
+		}
+		return items[i].ID < items[j].ID
+	})
+	index = &bookSuggestIndex{
+		version: version,
+		items:   items,
+	}
+	return
+}
+
+// expireBookSuggestIndex expire the suggest index, it will be rebuilt when it is used
+func expireBookSuggestIndex() {
+	bookSuggestMutex.Lock()
+	defer bookSuggestMutex.Unlock()
+	bookSuggestExpired = true
+}
+
+// getBookSuggestIndex get the suggest index
+func getBookSuggestIndex() (index *bookSuggestIndex, expired bool) {
+	bookSuggestMutex.RLock()
+	defer bookSuggestMutex.RUnlock()
+	return currentBookSuggestIndex, bookSuggestExpired
+}
+
+// RefreshSuggestIndex rebuild the suggest index if the books are modified
+func (srv *BookSrv) RefreshSuggestIndex() (err error) {
+	bookSuggestBuildMutex.Lock()
+	defer bookSuggestBuildMutex.Unlock()
+	version, err := getBookSuggestVersion()
+	if err != nil {
+		return
+	}
+	index, expired := getBookSuggestIndex()
+	if !expired && index != nil && index.version == version {
+		return
+	}
+	index, err = newBookSuggestIndex(version)
+	if err != nil {
+		return
+	}
+	bookSuggestMutex.Lock()
+	defer bookSuggestMutex.Unlock()
+	currentBookSuggestIndex = index
+	bookSuggestExpired = false
+	return
+}
+
+// Suggest suggest the books whose name is matched the query
+// (prefix of name, full pinyin or pinyin initials), they are sorted by hot score
+func (srv *BookSrv) Suggest(q string, limit int) (result []*BookSuggestion, err error) {
+	result = make([]*BookSuggestion, 0)
+	q = normalizeSuggestQuery(q)
+	if q == "" {
+		return
+	}
+	if limit <= 0 {
+		limit = defaultBookSuggestLimit
+	}
+	index, expired := getBookSuggestIndex()
+	// 首次使用或书籍有调整时重建索引
+	if index == nil || expired {
+		err = srv.RefreshSuggestIndex()
+		if err != nil {
+			return
+		}
+		index, _ = getBookSuggestIndex()
+	}
+	for _, item := range index.items {
+		if !item.match(q) {
+			continue
+		}
+		result = append(result, item.BookSuggestion)
+		if len(result) >= limit {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/util"
+	"go.uber.org/zap"
+)
+
+const (
+	bookSyncJobLockKey = "book-sync-job"
+	// 同步任务的最长时间，超时后锁自动释放
+	bookSyncJobLockTTL = 2 * time.Hour
+
+	defaultBookSyncJobLimit = 10
+)
+
+var (
+	errBookSyncJobRunning     = hes.New("book sync job is running, please wait for it done")
+	errBookSyncJobNotRunning  = hes.New("book sync job is not running")
+	errBookSyncJobOtherServer = hes.New("book sync job is running on other server")
+
+	// 当前实例运行中的同步任务
+	bookSyncJobCancels = make(map[uint]context.CancelFunc)
+	bookSyncJobMutex   = new(sync.Mutex)
+)
+
+type (
+	// BookSyncJob book sync job
+	BookSyncJob struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		// 同步来源(file, archive)
+		Source string `json:"source,omitempty" gorm:"type:varchar(20);not null;"`
+		// 触发同步的账号
+		Account string `json:"account,omitempty" gorm:"type:varchar(20);not null;"`
+		Status  int    `json:"status,omitempty" gorm:"index:idx_book_sync_jobs_status"`
+		// 书籍总数与已完成数
+		Total int `json:"total,omitempty"`
+		Done  int `json:"done,omitempty"`
+		// 章节的变化数
+		Added     int `json:"added,omitempty"`
+		Changed   int `json:"changed,omitempty"`
+		Removed   int `json:"removed,omitempty"`
+		Unchanged int `json:"unchanged,omitempty"`
+
+		StartedAt *time.Time `json:"startedAt,omitempty"`
+		EndedAt   *time.Time `json:"endedAt,omitempty"`
+		Error     string     `json:"error,omitempty"`
+		// 每本书的同步结果
+		Report postgres.Jsonb `json:"report,omitempty" gorm:"type:jsonb"`
+	}
+	// BookSyncJobParams book sync job params
+	BookSyncJobParams struct {
+		Source  string
+		Account string
+		Path    string
+		// OnDone 同步任务结束时回调（如清除上传的临时文件）
+		OnDone func()
+	}
+	// BookSyncJobQueryParams book sync job query params
+	BookSyncJobQueryParams struct {
+		Status int
+		Limit  int
+		Offset int
+	}
+	// BookSyncJobSrv book sync job service
+	BookSyncJobSrv struct {
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&BookSyncJob{})
+}
+
+// IsRunning check the job is running
+func (job *BookSyncJob) IsRunning() bool {
+	return job.Status == cs.BookSyncJobRunning
+}
+
+func (srv *BookSyncJobSrv) update(id uint, data map[string]interface{}) {
+	err := pgGetClient().Model(&BookSyncJob{
+		ID: id,
+	}).Updates(data).Error
+	if err != nil {
+		logger.Error("update book sync job fail",
+			zap.Uint("id", id),
+			zap.Error(err),
+		)
+	}
+}
+
+// Start start a book sync job, only one job can be running at the same time
+func (srv *BookSyncJobSrv) Start(params BookSyncJobParams) (job *BookSyncJob, err error) {
+	done := func() {
+		if params.OnDone != nil {
+			params.OnDone()
+		}
+	}
+	success, unlock, err := redisSrv.LockWithDone(bookSyncJobLockKey, bookSyncJobLockTTL)
+	if err != nil || !success {
+		done()
+		if err == nil {
+			err = errBookSyncJobRunning
+		}
+		return
+	}
+	now := util.Now()
+	job = &BookSyncJob{
+		Source:    params.Source,
+		Account:   params.Account,
+		Status:    cs.BookSyncJobRunning,
+		StartedAt: &now,
+	}
+	err = pgCreate(job)
+	if err != nil {
+		unlock()
+		done()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bookSyncJobMutex.Lock()
+	bookSyncJobCancels[job.ID] = cancel
+	bookSyncJobMutex.Unlock()
+
+	id := job.ID
+	go func() {
+		defer func() {
+			bookSyncJobMutex.Lock()
+			delete(bookSyncJobCancels, id)
+			bookSyncJobMutex.Unlock()
+			cancel()
+			unlock()
+			done()
+		}()
+		var added, changed, removed, unchanged int
+		results, err := new(BookSrv).SyncFromFile(ctx, BookSyncParams{
+			Path:    params.Path,
+			Account: params.Account,
+			OnProgress: func(finished, total int, result *BookSyncResult) {
+				data := map[string]interface{}{
+					"total": total,
+					"done":  finished,
+				}
+				if result != nil {
+					added += len(result.Added)
+					changed += len(result.Changed)
+					removed += len(result.Removed)
+					unchanged += result.Unchanged
+					data["added"] = added
+					data["changed"] = changed
+					data["removed"] = removed
+					data["unchanged"] = unchanged
+				}
+				srv.update(id, data)
+			},
+		})
+		endedAt := util.Now()
+		data := map[string]interface{}{
+			"status":   cs.BookSyncJobDone,
+			"ended_at": &endedAt,
+		}
+		report, e := standardJSON.Marshal(results)
+		if e == nil {
+			data["report"] = postgres.Jsonb{
+				RawMessage: report,
+			}
+		}
+		if err != nil {
+			data["error"] = err.Error()
+			data["status"] = cs.BookSyncJobFailed
+			if err == context.Canceled {
+				data["status"] = cs.BookSyncJobCanceled
+			}
+			logger.Error("book sync job fail",
+				zap.Uint("id", id),
+				zap.Error(err),
+			)
+		}
+		srv.update(id, data)
+	}()
+	return
+}
+
+// Cancel cancel the running job
+func (srv *BookSyncJobSrv) Cancel(id uint) (err error) {
+	job, err := srv.GetByID(id)
+	if err != nil {
+		return
+	}
+	if !job.IsRunning() {
+		err = errBookSyncJobNotRunning
+		return
+	}
+	bookSyncJobMutex.Lock()
+	cancel := bookSyncJobCancels[id]
+	bookSyncJobMutex.Unlock()
+	if cancel != nil {
+		cancel()
+		return
+	}
+	// 如果锁已不存在，则任务已中断（如程序重启），直接设置为已取消
+	value, err := redisSrv.Get(bookSyncJobLockKey)
+	if err != nil {
+		return
+	}
+	if value != "" {
+		err = errBookSyncJobOtherServer
+		return
+	}
+	endedAt := util.Now()
+	srv.update(id, map[string]interface{}{
+		"status":   cs.BookSyncJobCanceled,
+		"ended_at": &endedAt,
+	})
+	return
+}
+
+// GetByID get book sync job by id
+func (srv *BookSyncJobSrv) GetByID(id uint) (job *BookSyncJob, err error) {
+	job = &BookSyncJob{
+		ID: id,
+	}
+	err = pgGetClient().First(job).Error
+	return
+}
+
+func newBookSyncJobQuery(params BookSyncJobQueryParams) *gorm.DB {
+	db := pgGetClient()
+	if params.Status != 0 {
+		db = db.Where("status = ?", params.Status)
+	}
+	return db
+}
+
+// List list book sync job
+func (srv *BookSyncJobSrv) List(params BookSyncJobQueryParams) (result []*BookSyncJob, err error) {
+	result = make([]*BookSyncJob, 0)
+	db := newBookSyncJobQuery(params)
+	if params.Limit <= 0 {
+		db = db.Limit(defaultBookSyncJobLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	if params.Offset > 0 {
+		db = db.Offset(params.Offset)
+	}
+	// 列表不返回详细的同步结果
+	err = db.Select("id, created_at, updated_at, source, account, status, total, done, added, changed, removed, unchanged, started_at, ended_at, error").
+		Order("id desc").
+		Find(&result).Error
+	return
+}
+
+// Count count book sync job
+func (srv *BookSyncJobSrv) Count(params BookSyncJobQueryParams) (count int, err error) {
+	db := newBookSyncJobQuery(params)
+	err = db.Model(&BookSyncJob{}).Count(&count).Error
+	return
+}
@@ -0,0 +1,410 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/cs"
+)
+
+const (
+	// 调整章节NO时先偏移至此值之后，避免与book_id_no唯一索引冲突
+	chapterNOShiftOffset = 1000000
+
+	defaultChapterEditLogLimit = 20
+)
+
+var (
+	errChapterNOInvalid = hes.New("chapter no is invalid")
+)
+
+type (
+	// ChapterEditLog the edit log of chapter
+	ChapterEditLog struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		BookID    uint   `json:"bookID,omitempty" gorm:"not null;index:idx_chapter_edit_logs_book"`
+		ChapterID uint   `json:"chapterID,omitempty"`
+		Account   string `json:"account,omitempty" gorm:"type:varchar(20);not null;"`
+		// 操作类型(add, update, delete, move)
+		Action string `json:"action,omitempty" gorm:"type:varchar(20);not null;"`
+		NO     uint   `json:"no"`
+		// 移动章节时的原NO
+		FromNO *uint  `json:"fromNO,omitempty"`
+		Title  string `json:"title,omitempty"`
+	}
+	// ChapterEditParams chapter edit params
+	ChapterEditParams struct {
+		Account string
+		BookID  uint
+		NO      uint
+		Title   string
+		Content string
+		// 修改说明（记录至revision）
+		Note string
+		// 发布状态，0表示不修改（新增时默认为已发布）
+		Status    int
+		PublishAt *time.Time
+		// 追加至最后（NO在事务中获取）
+		Append bool
+	}
+	// ChapterEditLogQueryParams chapter edit log query params
+	ChapterEditLogQueryParams struct {
+		BookID uint
+		Offset int
+		Limit  int
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&ChapterEditLog{})
+}
+
+// lockBook lock the book for update in transaction,
+// it returns the bound of chapter no(max no + 1 of all chapters)
+func lockBook(tx *gorm.DB, bookID uint) (count uint, err error) {
+	book := &Book{}
+	err = tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id").
+		Where("id = ?", bookID).
+		First(book).Error
+	if err != nil {
+		return
+	}
+	// 书籍的chapter_count仅统计已发布章节，调整NO时需要以全部章节（包括未发布与软删除）为准
+	result := &struct {
+		Count uint
+	}{}
+	err = tx.Raw(`SELECT COALESCE(MAX(no) + 1, 0) AS count FROM chapters WHERE book_id = ?`, bookID).
+		Scan(result).Error
+	if err != nil {
+		return
+	}
+	count = result.Count
+	return
+}
+
+// shiftChapters shift the no of chapters which are in [begin, end] by delta,
+// the comments of chapters are shifted too
+func shiftChapters(tx *gorm.DB, bookID, begin, end uint, delta int) (err error) {
+	// 评论以(book_id, no)关联章节，需要同时调整
+	for _, table := range []string{"chapters", "comments"} {
+		err = tx.Exec(`UPDATE `+table+` SET no = no + ?
+			WHERE book_id = ? AND no BETWEEN ? AND ?`,
+			chapterNOShiftOffset, bookID, begin, end,
+		).Error
+		if err != nil {
+			return
+		}
+		err = tx.Exec(`UPDATE `+table+` SET no = no - ? + ?
+			WHERE book_id = ? AND no BETWEEN ? AND ?`,
+			chapterNOShiftOffset, delta, bookID, begin+chapterNOShiftOffset, end+chapterNOShiftOffset,
+		).Error
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// setChapterNO set the no of chapter and its comments
+func setChapterNO(tx *gorm.DB, chapter *Chapter, no uint) (err error) {
+	err = tx.Exec(`UPDATE comments SET no = ? WHERE book_id = ? AND no = ?`,
+		no, chapter.BookID, chapter.NO,
+	).Error
+	if err != nil {
+		return
+	}
+	err = tx.Model(chapter).UpdateColumn("no", no).Error
+	if err != nil {
+		return
+	}
+	chapter.NO = no
+	return
+}
+
+// createChapter create the chapter and its first revision
+func createChapter(tx *gorm.DB, chapter *Chapter, account, note string) (err error) {
+	chapter.WordCount = len(chapter.Content)
+	chapter.Hash = getChapterHash(chapter.Title, chapter.Content)
+	err = tx.Create(chapter).Error
+	if err != nil {
+		return
+	}
+	err = addChapterRevision(tx, chapter, account, note)
+	return
+}
+
+// updateChapterContent update the title and content of chapter, and add revision for it
+func updateChapterContent(tx *gorm.DB, chapter *Chapter, title, content, account, note string) (err error) {
+	// 旧章节未有revision，先保存修改前的内容
+	err = addChapterOriginalRevision(tx, chapter.ID)
+	if err != nil {
+		return
+	}
+	chapter.Title = title
+	chapter.Content = content
+	chapter.WordCount = len(content)
+	chapter.Hash = getChapterHash(title, content)
+	err = tx.Model(&Chapter{}).Where("id = ?", chapter.ID).Updates(map[string]interface{}{
+		"title":      chapter.Title,
+		"content":    chapter.Content,
+		"word_count": chapter.WordCount,
+		"hash":       chapter.Hash,
+	}).Error
+	if err != nil {
+		return
+	}
+	err = addChapterRevision(tx, chapter, account, note)
+	return
+}
+
+// editChapter run the edit function of chapter in transaction,
+// the edit log will be added and the book info will be updated
+func editChapter(bookID uint, fn func(tx *gorm.DB, count uint) (*ChapterEditLog, error)) (err error) {
+	tx := pgGetClient().Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	count, err := lockBook(tx, bookID)
+	if err != nil {
+		return
+	}
+	log, err := fn(tx, count)
+	if err != nil {
+		return
+	}
+	log.BookID = bookID
+	err = tx.Create(log).Error
+	if err != nil {
+		return
+	}
+	err = updateBookExtraInfo(tx, bookID)
+	if err != nil {
+		return
+	}
+	err = tx.Commit().Error
+	return
+}
+
+// AddChapter add chapter to the no of book, the chapters after it will be moved backward
+func (srv *BookSrv) AddChapter(params ChapterEditParams) (chapter *Chapter, err error) {
+	if params.Status == 0 {
+		params.Status = cs.PublishPublished
+	}
+	err = checkPublishParams(params.Status, params.PublishAt)
+	if err != nil {
+		return
+	}
+	err = editChapter(params.BookID, func(tx *gorm.DB, count uint) (log *ChapterEditLog, err error) {
+		if params.Append {
+			params.NO = count
+		}
+		if params.NO > count {
+			err = errChapterNOInvalid
+			return
+		}
+		if params.NO < count {
+			err = shiftChapters(tx, params.BookID, params.NO, count-1, 1)
+			if err != nil {
+				return
+			}
+		}
+		chapter = &Chapter{
+			BookID:    params.BookID,
+			NO:        params.NO,
+			Title:     params.Title,
+			Content:   params.Content,
+			Status:    params.Status,
+			PublishAt: getPublishAt(params.Status, params.PublishAt),
+		}
+		err = createChapter(tx, chapter, params.Account, params.Note)
+		if err != nil {
+			return
+		}
+		log = &ChapterEditLog{
+			ChapterID: chapter.ID,
+			Account:   params.Account,
+			Action:    cs.ChapterEditAdd,
+			NO:        chapter.NO,
+			Title:     chapter.Title,
+		}
+		return
+	})
+	return
+}
+
+// UpdateChapter update the title, content or publish status of chapter,
+// the revision is only added when the title or content is modified
+func (srv *BookSrv) UpdateChapter(params ChapterEditParams) (err error) {
+	if params.Status != 0 {
+		err = checkPublishParams(params.Status, params.PublishAt)
+		if err != nil {
+			return
+		}
+	}
+	err = editChapter(params.BookID, func(tx *gorm.DB, _ uint) (log *ChapterEditLog, err error) {
+		chapter := &Chapter{}
+		err = tx.Where("book_id = ? AND no = ?", params.BookID, params.NO).First(chapter).Error
+		if err != nil {
+			return
+		}
+		if params.Title != "" || params.Content != "" {
+			title := chapter.Title
+			if params.Title != "" {
+				title = params.Title
+			}
+			content := chapter.Content
+			if params.Content != "" {
+				content = params.Content
+			}
+			err = updateChapterContent(tx, chapter, title, content, params.Account, params.Note)
+			if err != nil {
+				return
+			}
+		}
+		if params.Status != 0 {
+			err = tx.Model(&Chapter{}).Where("id = ?", chapter.ID).Updates(map[string]interface{}{
+				"status":     params.Status,
+				"publish_at": getPublishAt(params.Status, params.PublishAt),
+			}).Error
+			if err != nil {
+				return
+			}
+		}
+		log = &ChapterEditLog{
+			ChapterID: chapter.ID,
+			Account:   params.Account,
+			Action:    cs.ChapterEditUpdate,
+			NO:        chapter.NO,
+			Title:     chapter.Title,
+		}
+		return
+	})
+	return
+}
+
+// DeleteChapter delete the chapter, the chapters after it will be moved forward
+func (srv *BookSrv) DeleteChapter(params ChapterEditParams) (err error) {
+	err = editChapter(params.BookID, func(tx *gorm.DB, count uint) (log *ChapterEditLog, err error) {
+		chapter := &Chapter{}
+		err = tx.Where("book_id = ? AND no = ?", params.BookID, params.NO).First(chapter).Error
+		if err != nil {
+			return
+		}
+		// 直接删除记录，避免软删除的记录占用book_id_no唯一索引
+		err = tx.Unscoped().Delete(chapter).Error
+		if err != nil {
+			return
+		}
+		err = deleteChapterComments(tx, params.BookID, chapter.NO)
+		if err != nil {
+			return
+		}
+		if chapter.NO+1 < count {
+			err = shiftChapters(tx, params.BookID, chapter.NO+1, count-1, -1)
+			if err != nil {
+				return
+			}
+		}
+		log = &ChapterEditLog{
+			ChapterID: chapter.ID,
+			Account:   params.Account,
+			Action:    cs.ChapterEditDelete,
+			NO:        chapter.NO,
+			Title:     chapter.Title,
+		}
+		return
+	})
+	return
+}
+
+// MoveChapter move the chapter to the no, the chapters between them will be renumbered
+func (srv *BookSrv) MoveChapter(params ChapterEditParams, to uint) (err error) {
+	err = editChapter(params.BookID, func(tx *gorm.DB, count uint) (log *ChapterEditLog, err error) {
+		if to >= count {
+			err = errChapterNOInvalid
+			return
+		}
+		chapter := &Chapter{}
+		err = tx.Where("book_id = ? AND no = ?", params.BookID, params.NO).First(chapter).Error
+		if err != nil {
+			return
+		}
+		from := chapter.NO
+		if from != to {
+			// 先将移动的章节（及其评论）设置为临时的NO
+			err = setChapterNO(tx, chapter, chapterNOShiftOffset*2)
+			if err != nil {
+				return
+			}
+			if from < to {
+				err = shiftChapters(tx, params.BookID, from+1, to, -1)
+			} else {
+				err = shiftChapters(tx, params.BookID, to, from-1, 1)
+			}
+			if err != nil {
+				return
+			}
+			err = setChapterNO(tx, chapter, to)
+			if err != nil {
+				return
+			}
+		}
+		log = &ChapterEditLog{
+			ChapterID: chapter.ID,
+			Account:   params.Account,
+			Action:    cs.ChapterEditMove,
+			NO:        to,
+			FromNO:    &from,
+			Title:     chapter.Title,
+		}
+		return
+	})
+	return
+}
+
+// ListChapterEditLog list the edit logs of book's chapters
+func (srv *BookSrv) ListChapterEditLog(params ChapterEditLogQueryParams) (result []*ChapterEditLog, err error) {
+	result = make([]*ChapterEditLog, 0)
+	db := pgGetClient().Where("book_id = ?", params.BookID)
+	if params.Limit <= 0 {
+		db = db.Limit(defaultChapterEditLogLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	if params.Offset > 0 {
+		db = db.Offset(params.Offset)
+	}
+	err = db.Order("id desc").Find(&result).Error
+	return
+}
+
+// CountChapterEditLog count the edit logs of book's chapters
+func (srv *BookSrv) CountChapterEditLog(params ChapterEditLogQueryParams) (count int, err error) {
+	err = pgGetClient().
+		Model(&ChapterEditLog{}).
+		Where("book_id = ?", params.BookID).
+		Count(&count).Error
+	return
+}
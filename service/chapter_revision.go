@@ -0,0 +1,196 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	chapterRevisionNoteSync     = "sync from file"
+	chapterRevisionNoteOriginal = "original"
+)
+
+var (
+	errChapterRevisionInvalid = hes.New("revision is not belong to the chapter")
+)
+
+type (
+	// ChapterRevision the immutable revision of chapter's title and content
+	ChapterRevision struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		ChapterID uint `json:"chapterID,omitempty" gorm:"not null;index:idx_chapter_revisions_chapter"`
+		BookID    uint `json:"bookID,omitempty" gorm:"not null"`
+		// 修改时章节的NO
+		NO      uint   `json:"no"`
+		Title   string `json:"title,omitempty"`
+		Content string `json:"content,omitempty"`
+		Hash    string `json:"hash,omitempty" gorm:"type:varchar(64)"`
+		// 修改的账号
+		Account string `json:"account,omitempty" gorm:"type:varchar(20)"`
+		// 修改说明
+		Note string `json:"note,omitempty" gorm:"type:varchar(200)"`
+	}
+	// ChapterRevisionDiff the diff of two revisions
+	ChapterRevisionDiff struct {
+		From      *ChapterRevision `json:"from,omitempty"`
+		To        *ChapterRevision `json:"to,omitempty"`
+		TitleDiff []*util.DiffLine `json:"titleDiff,omitempty"`
+		Diff      []*util.DiffLine `json:"diff,omitempty"`
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&ChapterRevision{})
+}
+
+// addChapterOriginalRevision add the current content of chapter as the first revision,
+// it's for the chapter which is created before revision is supported
+func addChapterOriginalRevision(db *gorm.DB, chapterID uint) error {
+	now := util.Now()
+	return db.Exec(`INSERT INTO chapter_revisions
+		(created_at, updated_at, chapter_id, book_id, no, title, content, hash, account, note)
+		SELECT updated_at, ?, id, book_id, no, title, content, hash, '', ?
+		FROM chapters
+		WHERE id = ? AND NOT EXISTS (
+			SELECT 1 FROM chapter_revisions WHERE chapter_id = ? AND deleted_at IS NULL
+		)`,
+		now, chapterRevisionNoteOriginal, chapterID, chapterID,
+	).Error
+}
+
+// addChapterRevision add the revision of chapter
+func addChapterRevision(db *gorm.DB, chapter *Chapter, account, note string) error {
+	return db.Create(&ChapterRevision{
+		ChapterID: chapter.ID,
+		BookID:    chapter.BookID,
+		NO:        chapter.NO,
+		Title:     chapter.Title,
+		Content:   chapter.Content,
+		Hash:      chapter.Hash,
+		Account:   account,
+		Note:      note,
+	}).Error
+}
+
+// ListChapterRevision list the revisions of chapter(without content)
+func (srv *BookSrv) ListChapterRevision(bookID, no uint) (result []*ChapterRevision, err error) {
+	result = make([]*ChapterRevision, 0)
+	chapter, err := srv.GetChapter(bookID, no)
+	if err != nil {
+		return
+	}
+	err = pgGetClient().
+		Select("id, created_at, updated_at, chapter_id, book_id, no, title, hash, account, note").
+		Where("chapter_id = ?", chapter.ID).
+		Order("id desc").
+		Find(&result).Error
+	return
+}
+
+// getChapterRevision get the revision of chapter
+func getChapterRevision(chapter *Chapter, id uint) (revision *ChapterRevision, err error) {
+	revision = &ChapterRevision{}
+	err = pgGetClient().Where("id = ?", id).First(revision).Error
+	if err != nil {
+		return
+	}
+	if revision.ChapterID != chapter.ID {
+		err = errChapterRevisionInvalid
+		return
+	}
+	return
+}
+
+// DiffChapterRevision get the line-level diff of two revisions,
+// the current chapter is used if the to revision is 0
+func (srv *BookSrv) DiffChapterRevision(bookID, no, from, to uint) (diff *ChapterRevisionDiff, err error) {
+	chapter, err := srv.GetChapter(bookID, no)
+	if err != nil {
+		return
+	}
+	fromRevision, err := getChapterRevision(chapter, from)
+	if err != nil {
+		return
+	}
+	var toRevision *ChapterRevision
+	if to == 0 {
+		toRevision = &ChapterRevision{
+			ChapterID: chapter.ID,
+			BookID:    chapter.BookID,
+			NO:        chapter.NO,
+			Title:     chapter.Title,
+			Content:   chapter.Content,
+			Hash:      chapter.Hash,
+		}
+	} else {
+		toRevision, err = getChapterRevision(chapter, to)
+		if err != nil {
+			return
+		}
+	}
+	diff = &ChapterRevisionDiff{
+		TitleDiff: util.DiffLines(fromRevision.Title, toRevision.Title),
+		Diff:      util.DiffLines(fromRevision.Content, toRevision.Content),
+	}
+	// 内容已在diff中，不再返回
+	fromRevision.Content = ""
+	toRevision.Content = ""
+	diff.From = fromRevision
+	diff.To = toRevision
+	return
+}
+
+// RollbackChapter rollback the chapter to the revision, a new revision will be added
+func (srv *BookSrv) RollbackChapter(params ChapterEditParams, revisionID uint) (err error) {
+	err = editChapter(params.BookID, func(tx *gorm.DB, _ uint) (log *ChapterEditLog, err error) {
+		chapter := &Chapter{}
+		err = tx.Where("book_id = ? AND no = ?", params.BookID, params.NO).First(chapter).Error
+		if err != nil {
+			return
+		}
+		revision, err := getChapterRevision(chapter, revisionID)
+		if err != nil {
+			return
+		}
+		note := params.Note
+		if note == "" {
+			note = fmt.Sprintf("rollback to revision %d", revision.ID)
+		}
+		err = updateChapterContent(tx, chapter, revision.Title, revision.Content, params.Account, note)
+		if err != nil {
+			return
+		}
+		log = &ChapterEditLog{
+			ChapterID: chapter.ID,
+			Account:   params.Account,
+			Action:    cs.ChapterEditRollback,
+			NO:        chapter.NO,
+			Title:     chapter.Title,
+		}
+		return
+	})
+	return
+}
@@ -0,0 +1,300 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"html"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	defaultCommentLimit = 10
+	// 被举报达到此次数的评论重新进入审核队列
+	commentReportThreshold = 3
+)
+
+var (
+	errCommentParentInvalid = hes.New("parent comment is invalid")
+	errCommentNotApproved   = hes.New("comment is not approved")
+	errCommentReported      = hes.New("comment has been reported")
+)
+
+type (
+	// Comment the comment of chapter
+	Comment struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		BookID  uint   `json:"bookID,omitempty" gorm:"not null;index:idx_comments_chapter"`
+		NO      uint   `json:"no" gorm:"not null;index:idx_comments_chapter"`
+		Account string `json:"account,omitempty" gorm:"type:varchar(20);not null;index:idx_comments_account"`
+		// 回复的评论，0表示非回复
+		ParentID uint `json:"parentID,omitempty"`
+		// 所属的顶层评论，用于按主题获取回复
+		RootID      uint   `json:"rootID,omitempty" gorm:"index:idx_comments_root"`
+		Content     string `json:"content,omitempty" gorm:"type:varchar(1000);not null"`
+		Status      int    `json:"status,omitempty" gorm:"index:idx_comments_status"`
+		ReportCount int    `json:"reportCount,omitempty"`
+
+		Replies []*Comment `json:"replies,omitempty" gorm:"-"`
+	}
+	// CommentReport the abuse report of comment
+	CommentReport struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		CommentID uint   `json:"commentID,omitempty" gorm:"not null;unique_index:idx_comment_reports_comment_account"`
+		Account   string `json:"account,omitempty" gorm:"type:varchar(20);not null;unique_index:idx_comment_reports_comment_account"`
+		Reason    string `json:"reason,omitempty" gorm:"type:varchar(200)"`
+	}
+	// CommentQueryParams comment query params
+	CommentQueryParams struct {
+		BookID uint
+		NO     uint
+		Status int
+		Offset int
+		Limit  int
+	}
+	// CommentSrv comment service
+	CommentSrv struct {
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&Comment{}).
+		AutoMigrate(&CommentReport{})
+}
+
+// escape escape the content of comment for output
+func (comment *Comment) escape() {
+	comment.Content = html.EscapeString(comment.Content)
+	for _, item := range comment.Replies {
+		item.escape()
+	}
+}
+
+// Add add comment, it should be approved before showing
+func (srv *CommentSrv) Add(comment *Comment) (err error) {
+	_, err = new(BookSrv).GetPublishedChapter(comment.BookID, comment.NO)
+	if err != nil {
+		return
+	}
+	if comment.ParentID != 0 {
+		parent, e := srv.GetByID(comment.ParentID)
+		if e != nil ||
+			parent.BookID != comment.BookID ||
+			parent.NO != comment.NO ||
+			parent.Status != cs.CommentApproved {
+			err = errCommentParentInvalid
+			return
+		}
+		comment.RootID = parent.RootID
+		if comment.RootID == 0 {
+			comment.RootID = parent.ID
+		}
+	}
+	comment.Status = cs.CommentPending
+	err = pgCreate(comment)
+	if err != nil {
+		return
+	}
+	comment.escape()
+	return
+}
+
+// deleteChapterComments delete the comments of chapter(the chapter is deleted)
+func deleteChapterComments(db *gorm.DB, bookID, no uint) (err error) {
+	err = db.Where("book_id = ? AND no = ?", bookID, no).Delete(&Comment{}).Error
+	return
+}
+
+// GetByID get comment by id
+func (srv *CommentSrv) GetByID(id uint) (comment *Comment, err error) {
+	comment = &Comment{
+		ID: id,
+	}
+	err = pgGetClient().First(comment).Error
+	return
+}
+
+// ListByChapter list the approved comments of chapter with replies
+func (srv *CommentSrv) ListByChapter(params CommentQueryParams) (result []*Comment, err error) {
+	result = make([]*Comment, 0)
+	db := pgGetClient().
+		Where("book_id = ? AND no = ? AND parent_id = 0 AND status = ?", params.BookID, params.NO, cs.CommentApproved)
+	if params.Limit <= 0 {
+		db = db.Limit(defaultCommentLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	if params.Offset > 0 {
+		db = db.Offset(params.Offset)
+	}
+	err = db.Order("id desc").Find(&result).Error
+	if err != nil || len(result) == 0 {
+		return
+	}
+	rootMap := make(map[uint]*Comment)
+	ids := make([]uint, len(result))
+	for index, item := range result {
+		ids[index] = item.ID
+		rootMap[item.ID] = item
+	}
+	replies := make([]*Comment, 0)
+	err = pgGetClient().
+		Where("root_id IN (?) AND status = ?", ids, cs.CommentApproved).
+		Order("id").
+		Find(&replies).Error
+	if err != nil {
+		return
+	}
+	for _, item := range replies {
+		root := rootMap[item.RootID]
+		if root != nil {
+			root.Replies = append(root.Replies, item)
+		}
+	}
+	for _, item := range result {
+		item.escape()
+	}
+	return
+}
+
+// CountByChapter count the approved comments of chapter(not include replies)
+func (srv *CommentSrv) CountByChapter(params CommentQueryParams) (count int, err error) {
+	err = pgGetClient().
+		Model(&Comment{}).
+		Where("book_id = ? AND no = ? AND parent_id = 0 AND status = ?", params.BookID, params.NO, cs.CommentApproved).
+		Count(&count).Error
+	return
+}
+
+// Report report the comment, the comment will be pending again
+// if the report count reaches the threshold
+func (srv *CommentSrv) Report(report *CommentReport) (err error) {
+	comment, err := srv.GetByID(report.CommentID)
+	if err != nil {
+		return
+	}
+	if comment.Status != cs.CommentApproved {
+		err = errCommentNotApproved
+		return
+	}
+	tx := pgGetClient().Begin()
+	now := util.Now()
+	db := tx.Exec(`INSERT INTO comment_reports
+		(created_at, updated_at, comment_id, account, reason)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (comment_id, account) DO NOTHING`,
+		now, now, report.CommentID, report.Account, report.Reason,
+	)
+	err = db.Error
+	if err == nil && db.RowsAffected == 0 {
+		err = errCommentReported
+	}
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Exec(`UPDATE comments SET
+		updated_at = ?,
+		report_count = report_count + 1,
+		status = CASE WHEN report_count + 1 >= ? THEN ? ELSE status END
+		WHERE id = ?`,
+		now, commentReportThreshold, cs.CommentPending, report.CommentID,
+	).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit().Error
+	return
+}
+
+func newCommentReviewQuery(params CommentQueryParams) *gorm.DB {
+	db := pgGetClient()
+	if params.Status != 0 {
+		db = db.Where("status = ?", params.Status)
+	}
+	return db
+}
+
+// ListReview list comments for review, the most reported ones are first
+func (srv *CommentSrv) ListReview(params CommentQueryParams) (result []*Comment, err error) {
+	result = make([]*Comment, 0)
+	db := newCommentReviewQuery(params)
+	if params.Limit <= 0 {
+		db = db.Limit(defaultCommentLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	if params.Offset > 0 {
+		db = db.Offset(params.Offset)
+	}
+	err = db.Order("report_count desc, id").Find(&result).Error
+	if err != nil {
+		return
+	}
+	for _, item := range result {
+		item.escape()
+	}
+	return
+}
+
+// CountReview count comments for review
+func (srv *CommentSrv) CountReview(params CommentQueryParams) (count int, err error) {
+	err = newCommentReviewQuery(params).Model(&Comment{}).Count(&count).Error
+	return
+}
+
+// ListReport list the reports of comment
+func (srv *CommentSrv) ListReport(commentID uint) (result []*CommentReport, err error) {
+	result = make([]*CommentReport, 0)
+	err = pgGetClient().
+		Where("comment_id = ?", commentID).
+		Order("id").
+		Find(&result).Error
+	if err != nil {
+		return
+	}
+	for _, item := range result {
+		item.Reason = html.EscapeString(item.Reason)
+	}
+	return
+}
+
+// UpdateStatus update the status of comment,
+// the report count will be reset if it is approved
+func (srv *CommentSrv) UpdateStatus(id uint, status int) (err error) {
+	data := map[string]interface{}{
+		"status": status,
+	}
+	if status == cs.CommentApproved {
+		data["report_count"] = 0
+	}
+	err = pgGetClient().Model(&Comment{
+		ID: id,
+	}).Updates(data).Error
+	return
+}
@@ -29,6 +29,7 @@ const (
 	sessionSignedKeyCateogry = "signedKey"
 	ipBlockCategory          = "ipBlock"
 	routerConfigCategory     = "routerConfig"
+	s2tDictCategory          = "s2tDict"
 
 	defaultConfigurationLimit = 100
 )
@@ -147,6 +148,7 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 	routerConfigs := make([]*Configuration, 0)
 	var signedKeysConfig *Configuration
 	blockIPList := make([]string, 0)
+	s2tDictConfigs := make([]*Configuration, 0)
 
 	for _, item := range configs {
 		if item.Name == mockTimeKey {
@@ -171,6 +173,12 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 			blockIPList = append(blockIPList, item.Data)
 			continue
 		}
+
+		// 简繁转换的词组覆盖
+		if item.Category == s2tDictCategory {
+			s2tDictConfigs = append(s2tDictConfigs, item)
+			continue
+		}
 	}
 
 	// 如果未配置mock time，则设置为空
@@ -192,6 +200,8 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 	updateRouterConfigs(routerConfigs)
 
 	ResetIPBlocker(blockIPList)
+
+	updateS2TDict(s2tDictConfigs)
 	return
 }
 
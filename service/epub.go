@@ -0,0 +1,360 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/vicanso/go-axios"
+	"github.com/vicanso/wsl/config"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/helper"
+	"go.uber.org/zap"
+)
+
+const (
+	coverService = "cover"
+
+	epubMimetype = "application/epub+zip"
+	epubLangSC   = "zh-Hans"
+)
+
+var (
+	coverIns *axios.Instance
+	// 缓存目录
+	cacheDir string
+	// 避免同一本书同时生成多次
+	epubMutex = new(sync.Mutex)
+
+	epubTemplateFuncs = template.FuncMap{
+		"escape": html.EscapeString,
+	}
+	epubContainerTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`
+	epubPackageTemplate = template.Must(template.New("package").Funcs(epubTemplateFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="{{.Lang}}">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:identifier id="book-id">urn:wsl:book:{{.Book.ID}}</dc:identifier>
+		<dc:title>{{escape .Book.Name}}</dc:title>
+		<dc:creator>{{escape .Book.Author}}</dc:creator>
+		<dc:language>{{.Lang}}</dc:language>
+		{{if .Book.Summary}}<dc:description>{{escape .Book.Summary}}</dc:description>{{end}}
+		<meta property="dcterms:modified">{{.Modified}}</meta>
+		{{if .Cover}}<meta name="cover" content="cover-image"/>{{end}}
+	</metadata>
+	<manifest>
+		<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+		{{if .Cover}}<item id="cover-image" href="{{.Cover.Name}}" media-type="{{.Cover.MediaType}}" properties="cover-image"/>{{end}}
+		{{range .Chapters}}<item id="chapter-{{.NO}}" href="chapter-{{.NO}}.xhtml" media-type="application/xhtml+xml"/>
+		{{end}}
+	</manifest>
+	<spine>
+		{{range .Chapters}}<itemref idref="chapter-{{.NO}}"/>
+		{{end}}
+	</spine>
+</package>`))
+	epubNavTemplate = template.Must(template.New("nav").Funcs(epubTemplateFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{.Lang}}">
+<head>
+	<title>{{escape .Book.Name}}</title>
+</head>
+<body>
+	<nav epub:type="toc" id="toc">
+		<h1>{{escape .Book.Name}}</h1>
+		<ol>
+			{{range .Chapters}}<li><a href="chapter-{{.NO}}.xhtml">{{escape .Title}}</a></li>
+			{{end}}
+		</ol>
+	</nav>
+</body>
+</html>`))
+	epubChapterTemplate = template.Must(template.New("chapter").Funcs(epubTemplateFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{.Lang}}">
+<head>
+	<title>{{escape .Chapter.Title}}</title>
+</head>
+<body>
+	<h2>{{escape .Chapter.Title}}</h2>
+	{{range .Paragraphs}}<p>{{escape .}}</p>
+	{{end}}
+</body>
+</html>`))
+)
+
+type (
+	epubCover struct {
+		Name      string
+		MediaType string
+		Data      []byte
+	}
+	epubPackage struct {
+		Lang     string
+		Modified string
+		Book     *Book
+		Chapters []*Chapter
+		Cover    *epubCover
+	}
+)
+
+func init() {
+	coverIns = helper.NewInstance(coverService, "", 10*time.Second)
+	cacheDir = config.GetStringDefault("resources.cache", filepath.Join(os.TempDir(), "wsl"))
+}
+
+// getEpubFile get the cache file of book's epub,
+// the name of file is changed when the book or s2t dict is updated
+func getEpubFile(book *Book, lang string) string {
+	name := fmt.Sprintf("book-%d-%s-%d-%s.epub", book.ID, lang, book.UpdatedAt.Unix(), GetS2TDictVersion())
+	return filepath.Join(cacheDir, "epub", name)
+}
+
+// fetchEpubCover fetch the cover of book, it will return nil if the cover is not supported
+func fetchEpubCover(url string) (cover *epubCover, err error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return
+	}
+	resp, err := coverIns.Get(url)
+	if err != nil {
+		return
+	}
+	mediaType := resp.Headers.Get("Content-Type")
+	ext := ""
+	switch {
+	case strings.HasPrefix(mediaType, "image/jpeg"):
+		ext = ".jpg"
+	case strings.HasPrefix(mediaType, "image/png"):
+		ext = ".png"
+	case strings.HasPrefix(mediaType, "image/gif"):
+		ext = ".gif"
+	default:
+		return
+	}
+	cover = &epubCover{
+		Name:      "cover" + ext,
+		MediaType: strings.Split(mediaType, ";")[0],
+		Data:      resp.Data,
+	}
+	return
+}
+
+// convertEpubPackage convert the content of epub to traditional chinese
+func convertEpubPackage(pkg *epubPackage) (err error) {
+	book := *pkg.Book
+	fields := []*string{
+		&book.Name,
+		&book.Author,
+		&book.Summary,
+	}
+	for _, chapter := range pkg.Chapters {
+		fields = append(fields, &chapter.Title, &chapter.Content)
+	}
+	for _, field := range fields {
+		*field, err = ConvertLang(pkg.Lang, *field)
+		if err != nil {
+			return
+		}
+	}
+	pkg.Book = &book
+	return
+}
+
+func writeEpubTemplate(w *zip.Writer, name string, tpl *template.Template, data interface{}) (err error) {
+	f, err := w.Create(name)
+	if err != nil {
+		return
+	}
+	err = tpl.Execute(f, data)
+	return
+}
+
+// writeEpub write the epub package to writer
+func writeEpub(writer io.Writer, pkg *epubPackage) (err error) {
+	w := zip.NewWriter(writer)
+	// mimetype必须是第一个文件而且不能压缩
+	f, err := w.CreateHeader(&zip.FileHeader{
+		Name:   "mimetype",
+		Method: zip.Store,
+	})
+	if err != nil {
+		return
+	}
+	_, err = f.Write([]byte(epubMimetype))
+	if err != nil {
+		return
+	}
+	f, err = w.Create("META-INF/container.xml")
+	if err != nil {
+		return
+	}
+	_, err = f.Write([]byte(epubContainerTemplate))
+	if err != nil {
+		return
+	}
+
+	err = writeEpubTemplate(w, "OEBPS/content.opf", epubPackageTemplate, pkg)
+	if err != nil {
+		return
+	}
+	err = writeEpubTemplate(w, "OEBPS/nav.xhtml", epubNavTemplate, pkg)
+	if err != nil {
+		return
+	}
+	if pkg.Cover != nil {
+		f, err = w.Create("OEBPS/" + pkg.Cover.Name)
+		if err != nil {
+			return
+		}
+		_, err = f.Write(pkg.Cover.Data)
+		if err != nil {
+			return
+		}
+	}
+	for _, chapter := range pkg.Chapters {
+		err = writeEpubTemplate(w, fmt.Sprintf("OEBPS/chapter-%d.xhtml", chapter.NO), epubChapterTemplate, map[string]interface{}{
+			"Lang":       pkg.Lang,
+			"Chapter":    chapter,
+			"Paragraphs": strings.Split(chapter.Content, "\n"),
+		})
+		if err != nil {
+			return
+		}
+	}
+	err = w.Close()
+	return
+}
+
+// removeExpiredEpub remove the expired epub file of book
+func removeExpiredEpub(book *Book, lang, current string) {
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "epub", fmt.Sprintf("book-%d-%s-*.epub", book.ID, lang)))
+	for _, file := range files {
+		if file != current {
+			os.Remove(file)
+		}
+	}
+}
+
+// GetEpub get epub of book, the epub file will be cached until the book is updated
+func (srv *BookSrv) GetEpub(id uint, lang string) (buf []byte, book *Book, err error) {
+	book, err = srv.GetPublishedByID(id)
+	if err != nil {
+		return
+	}
+	if !IsTraditionalLang(lang) {
+		lang = epubLangSC
+	}
+	file := getEpubFile(book, lang)
+	buf, err = ioutil.ReadFile(file)
+	if err == nil {
+		return
+	}
+
+	epubMutex.Lock()
+	defer epubMutex.Unlock()
+	// 有可能在等待锁时已生成
+	buf, err = ioutil.ReadFile(file)
+	if err == nil {
+		return
+	}
+
+	chapters := make([]*Chapter, 0)
+	err = pgGetClient().
+		Where("book_id = ? AND status = ?", id, cs.PublishPublished).
+		Order("no").
+		Find(&chapters).Error
+	if err != nil {
+		return
+	}
+	pkg := &epubPackage{
+		Lang:     lang,
+		Modified: book.UpdatedAt.UTC().Format(time.RFC3339),
+		Book:     book,
+		Chapters: chapters,
+	}
+	if book.Cover != "" {
+		cover, e := fetchEpubCover(book.Cover)
+		// 获取封面失败不影响epub的生成
+		if e != nil {
+			logger.Error("fetch book cover fail",
+				zap.Uint("id", book.ID),
+				zap.String("cover", book.Cover),
+				zap.Error(e),
+			)
+		}
+		pkg.Cover = cover
+	} else {
+		// 无封面时使用生成的封面
+		data, e := getBookCoverImage(book, BookCoverKindCover, lang)
+		if e != nil {
+			logger.Error("generate book cover fail",
+				zap.Uint("id", book.ID),
+				zap.Error(e),
+			)
+		} else {
+			pkg.Cover = &epubCover{
+				Name:      "cover.png",
+				MediaType: "image/png",
+				Data:      data,
+			}
+		}
+	}
+	if IsTraditionalLang(lang) {
+		err = convertEpubPackage(pkg)
+		if err != nil {
+			return
+		}
+	}
+	buffer := new(bytes.Buffer)
+	err = writeEpub(buffer, pkg)
+	if err != nil {
+		return
+	}
+	buf = buffer.Bytes()
+
+	// 写入缓存失败则只输出日志
+	e := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if e == nil {
+		tmpFile := file + ".tmp"
+		e = ioutil.WriteFile(tmpFile, buf, 0600)
+		if e == nil {
+			e = os.Rename(tmpFile, file)
+		}
+	}
+	if e != nil {
+		logger.Error("write epub cache fail",
+			zap.String("file", file),
+			zap.Error(e),
+		)
+		return
+	}
+	removeExpiredEpub(book, lang, file)
+	return
+}
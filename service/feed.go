@@ -0,0 +1,64 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/vicanso/wsl/cs"
+)
+
+const (
+	defaultFeedLimit = 20
+	// 章节摘要的字数
+	feedSummaryLength = 200
+)
+
+type (
+	// FeedChapter the new chapter of feed
+	FeedChapter struct {
+		ID        uint
+		BookID    uint
+		BookName  string
+		NO        uint
+		Title     string
+		Summary   string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+)
+
+// ListNewChapters list the new published chapters order by created at desc,
+// the chapters of all books are listed if the book id is 0
+func (srv *BookSrv) ListNewChapters(bookID uint, limit int) (result []*FeedChapter, err error) {
+	result = make([]*FeedChapter, 0)
+	if limit <= 0 {
+		limit = defaultFeedLimit
+	}
+	db := pgGetClient().
+		Table("chapters").
+		Select(`chapters.id, chapters.book_id, books.name AS book_name,
+			chapters.no, chapters.title, LEFT(chapters.content, ?) AS summary,
+			chapters.created_at, chapters.updated_at`, feedSummaryLength).
+		Joins("JOIN books ON books.id = chapters.book_id AND books.deleted_at IS NULL AND books.status = ?", cs.PublishPublished).
+		Where("chapters.deleted_at IS NULL AND chapters.status = ?", cs.PublishPublished)
+	if bookID != 0 {
+		db = db.Where("chapters.book_id = ?", bookID)
+	}
+	err = db.Order("chapters.created_at desc, chapters.id desc").
+		Limit(limit).
+		Scan(&result).Error
+	return
+}
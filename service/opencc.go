@@ -0,0 +1,181 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/vicanso/gocc"
+	"github.com/vicanso/wsl/config"
+	"github.com/vicanso/wsl/cs"
+)
+
+const (
+	defaultConvertCacheSize = 200
+)
+
+var (
+	// 简体转繁体
+	s2tOpenCC *gocc.OpenCC
+	// 繁体转简体（用于搜索关键字）
+	t2sOpenCC *gocc.OpenCC
+	// 各繁体语言的转换
+	langOpenCCs = make(map[string]*gocc.OpenCC)
+	// 转换结果的缓存
+	convertCache *lru.Cache
+
+	// 语言对应的opencc配置
+	langOpenCCConfigs = map[string]string{
+		cs.LangTC: "s2t",
+		cs.LangTW: "s2tw",
+		cs.LangHK: "s2hk",
+	}
+	// Accept-Language中的语言对应的繁体语言
+	acceptLangs = map[string]string{
+		"zh-hant":    cs.LangTC,
+		"zh-tw":      cs.LangTW,
+		"zh-hant-tw": cs.LangTW,
+		"zh-hk":      cs.LangHK,
+		"zh-mo":      cs.LangHK,
+		"zh-hant-hk": cs.LangHK,
+		"zh-hant-mo": cs.LangHK,
+	}
+)
+
+func init() {
+	for lang, name := range langOpenCCConfigs {
+		openCC, err := gocc.New(name)
+		if err != nil {
+			panic(err)
+		}
+		langOpenCCs[lang] = openCC
+	}
+	s2tOpenCC = langOpenCCs[cs.LangTC]
+	openCC, err := gocc.New("t2s")
+	if err != nil {
+		panic(err)
+	}
+	t2sOpenCC = openCC
+
+	cache, err := lru.New(config.GetIntDefault("opencc.cacheSize", defaultConvertCacheSize))
+	if err != nil {
+		panic(err)
+	}
+	convertCache = cache
+}
+
+// ConvertS2T convert simplified chinese to traditional chinese
+func ConvertS2T(text string) (string, error) {
+	return getS2TDict().convert(text, s2tOpenCC.Convert)
+}
+
+// ConvertT2S convert traditional chinese to simplified chinese
+func ConvertT2S(text string) (string, error) {
+	return t2sOpenCC.Convert(text)
+}
+
+// NormalizeKeyword convert the keyword to simplified chinese for search,
+// the keyword is returned if convert fail
+func NormalizeKeyword(keyword string) string {
+	if keyword == "" {
+		return keyword
+	}
+	result, err := ConvertT2S(keyword)
+	if err != nil || result == "" {
+		return keyword
+	}
+	return result
+}
+
+// IsTraditionalLang check the lang is traditional chinese(include regional variants)
+func IsTraditionalLang(lang string) bool {
+	return langOpenCCs[lang] != nil
+}
+
+// GetTraditionalLangs get the traditional chinese langs, the longer one is first
+// (the same length ones are sorted by name)
+func GetTraditionalLangs() []string {
+	langs := make([]string, 0, len(langOpenCCs))
+	for lang := range langOpenCCs {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if len(langs[i]) != len(langs[j]) {
+			return len(langs[i]) > len(langs[j])
+		}
+		return langs[i] < langs[j]
+	})
+	return langs
+}
+
+// ConvertLang convert simplified chinese to the lang,
+// the text is returned if the lang is not traditional chinese
+func ConvertLang(lang, text string) (string, error) {
+	openCC := langOpenCCs[lang]
+	if openCC == nil {
+		return text, nil
+	}
+	return getS2TDict().convert(text, openCC.Convert)
+}
+
+// ConvertLangWithCache convert simplified chinese to the lang,
+// the result is cached by the key(e.g. etag of content)
+func ConvertLangWithCache(lang, key, text string) (result string, err error) {
+	if key == "" {
+		return ConvertLang(lang, text)
+	}
+	cacheKey := lang + ":" + key
+	value, ok := convertCache.Get(cacheKey)
+	if ok {
+		return value.(string), nil
+	}
+	result, err = ConvertLang(lang, text)
+	if err != nil {
+		return
+	}
+	convertCache.Add(cacheKey, result)
+	return
+}
+
+// NegotiateLang get the traditional chinese lang from accept language,
+// it returns empty string if simplified chinese is preferred
+func NegotiateLang(acceptLanguage string) string {
+	lang := ""
+	maxQuality := 0.0
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		arr := strings.Split(strings.TrimSpace(item), ";")
+		tag := strings.ToLower(strings.TrimSpace(arr[0]))
+		if tag != "zh" && !strings.HasPrefix(tag, "zh-") {
+			continue
+		}
+		quality := 1.0
+		for _, param := range arr[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		// 取权重最高的中文语言
+		if quality <= maxQuality {
+			continue
+		}
+		maxQuality = quality
+		lang = acceptLangs[tag]
+	}
+	return lang
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/cs"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	publishScheduledLockKey = "publish-scheduled"
+)
+
+var (
+	errPublishAtRequired = hes.New("publish at is required for scheduled publishing")
+	errPublishAtExpired  = hes.New("publish at should be after now")
+)
+
+// checkPublishParams check the status and publish at
+func checkPublishParams(status int, publishAt *time.Time) (err error) {
+	if status != cs.PublishScheduled {
+		return
+	}
+	if publishAt == nil || publishAt.IsZero() {
+		err = errPublishAtRequired
+		return
+	}
+	if !publishAt.After(util.Now()) {
+		err = errPublishAtExpired
+		return
+	}
+	return
+}
+
+// getPublishAt get the publish at of status, it is only kept for scheduled
+func getPublishAt(status int, publishAt *time.Time) *time.Time {
+	if status != cs.PublishScheduled {
+		return nil
+	}
+	return publishAt
+}
+
+// UpdatePublish update the publish status of book
+func (srv *BookSrv) UpdatePublish(id uint, status int, publishAt *time.Time) (err error) {
+	err = checkPublishParams(status, publishAt)
+	if err != nil {
+		return
+	}
+	// 使用map更新，非定时发布时清除publish_at
+	err = pgGetClient().Model(&Book{
+		ID: id,
+	}).Updates(map[string]interface{}{
+		"status":     status,
+		"publish_at": getPublishAt(status, publishAt),
+	}).Error
+	if err != nil {
+		return
+	}
+	expireBookSuggestIndex()
+	return
+}
+
+// PublishScheduled publish the scheduled books and chapters
+// whose publish at is reached
+func (srv *BookSrv) PublishScheduled() (err error) {
+	// 多实例时只有一个实例执行
+	success, done, err := redisSrv.LockWithDone(publishScheduledLockKey, time.Minute)
+	if err != nil || !success {
+		return
+	}
+	defer done()
+	now := util.Now()
+	err = pgGetClient().Exec(`UPDATE books SET
+		updated_at = ?,
+		status = ?,
+		publish_at = NULL
+		WHERE status = ? AND publish_at <= ? AND deleted_at IS NULL`,
+		now, cs.PublishPublished, cs.PublishScheduled, now,
+	).Error
+	if err != nil {
+		return
+	}
+
+	bookIDs := make([]uint, 0)
+	err = pgGetClient().Model(&Chapter{}).
+		Where("status = ? AND publish_at <= ?", cs.PublishScheduled, now).
+		Pluck("DISTINCT book_id", &bookIDs).Error
+	if err != nil || len(bookIDs) == 0 {
+		return
+	}
+	err = pgGetClient().Exec(`UPDATE chapters SET
+		updated_at = ?,
+		status = ?,
+		publish_at = NULL
+		WHERE status = ? AND publish_at <= ? AND deleted_at IS NULL`,
+		now, cs.PublishPublished, cs.PublishScheduled, now,
+	).Error
+	if err != nil {
+		return
+	}
+	// 更新书籍的章节数与字数（同时更新了书籍的updated_at）
+	for _, id := range bookIDs {
+		err = updateBookExtraInfo(pgGetClient(), id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	defaultReadingProgressLimit = 10
+)
+
+var (
+	errReadingOwnerNil = hes.New("account and track id are nil")
+)
+
+type (
+	// ReadingProgress reading progress of book,
+	// the owner is account(logined) or track id(anonymous)
+	ReadingProgress struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		Account string `json:"account,omitempty" gorm:"type:varchar(20);not null;unique_index:idx_reading_progresses_owner_book"`
+		TrackID string `json:"trackId,omitempty" gorm:"type:varchar(64);not null;unique_index:idx_reading_progresses_owner_book"`
+		BookID  uint   `json:"bookID,omitempty" gorm:"not null;unique_index:idx_reading_progresses_owner_book"`
+		// 章节NO
+		NO uint `json:"no"`
+		// 章节的滚动位置
+		ScrollOffset int `json:"scrollOffset"`
+		// 最后阅读时间
+		ReadAt time.Time `json:"readAt,omitempty" gorm:"index:idx_reading_progresses_read_at"`
+		// 最后阅读时书籍的章节数，用于计算新增的未读章节
+		ChapterCount int `json:"chapterCount,omitempty"`
+
+		Book *Book `json:"book,omitempty" gorm:"-"`
+	}
+	// ReadingOwner the owner of reading progress
+	ReadingOwner struct {
+		Account string
+		TrackID string
+	}
+	// ReadingProgressQueryParams reading progress query params
+	ReadingProgressQueryParams struct {
+		Owner ReadingOwner
+		Limit int
+	}
+	// ReadingSrv reading service
+	ReadingSrv struct {
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&ReadingProgress{})
+}
+
+// 登录用户以账号为准，不记录track id
+func (owner ReadingOwner) where(db *gorm.DB) (*gorm.DB, error) {
+	if owner.Account != "" {
+		return db.Where("account = ? AND track_id = ''", owner.Account), nil
+	}
+	if owner.TrackID != "" {
+		return db.Where("account = '' AND track_id = ?", owner.TrackID), nil
+	}
+	return nil, errReadingOwnerNil
+}
+
+func (owner ReadingOwner) values() (account, trackID string) {
+	if owner.Account != "" {
+		return owner.Account, ""
+	}
+	return "", owner.TrackID
+}
+
+// getBriefBookMap get the brief info of books
+func getBriefBookMap(ids []uint) (bookMap map[uint]*Book, err error) {
+	books := make([]*Book, 0)
+	err = pgGetClient().
+		Select("id, name, author, cover, chapter_count, updated_at").
+		Where("id IN (?)", ids).
+		Find(&books).Error
+	if err != nil {
+		return
+	}
+	bookMap = make(map[uint]*Book)
+	for _, book := range books {
+		bookMap[book.ID] = book
+	}
+	return
+}
+
+// UpsertProgress add or update the reading progress,
+// it will be ignored if the read at is before the saved one (sync from other device)
+func (srv *ReadingSrv) UpsertProgress(owner ReadingOwner, progress *ReadingProgress) (err error) {
+	if owner.Account == "" && owner.TrackID == "" {
+		err = errReadingOwnerNil
+		return
+	}
+	// 确认书籍存在
+	book, err := new(BookSrv).GetPublishedByID(progress.BookID)
+	if err != nil {
+		return
+	}
+	account, trackID := owner.values()
+	now := util.Now()
+	if progress.ReadAt.IsZero() || progress.ReadAt.After(now) {
+		progress.ReadAt = now
+	}
+	err = pgGetClient().Exec(`INSERT INTO reading_progresses
+		(created_at, updated_at, account, track_id, book_id, no, scroll_offset, read_at, chapter_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account, track_id, book_id) DO UPDATE SET
+			updated_at = EXCLUDED.updated_at,
+			no = EXCLUDED.no,
+			scroll_offset = EXCLUDED.scroll_offset,
+			read_at = EXCLUDED.read_at,
+			chapter_count = EXCLUDED.chapter_count,
+			deleted_at = NULL
+		WHERE reading_progresses.read_at <= EXCLUDED.read_at`,
+		now, now, account, trackID, progress.BookID, progress.NO, progress.ScrollOffset, progress.ReadAt, book.ChapterCount,
+	).Error
+	return
+}
+
+// GetProgress get the reading progress of book
+func (srv *ReadingSrv) GetProgress(owner ReadingOwner, bookID uint) (progress *ReadingProgress, err error) {
+	db, err := owner.where(pgGetClient())
+	if err != nil {
+		return
+	}
+	progress = &ReadingProgress{}
+	err = db.Where("book_id = ?", bookID).First(progress).Error
+	return
+}
+
+// ListProgress list the reading progress order by read at desc (continue reading)
+func (srv *ReadingSrv) ListProgress(params ReadingProgressQueryParams) (result []*ReadingProgress, err error) {
+	result = make([]*ReadingProgress, 0)
+	db, err := params.Owner.where(pgGetClient())
+	if err != nil {
+		return
+	}
+	if params.Limit <= 0 {
+		db = db.Limit(defaultReadingProgressLimit)
+	} else {
+		db = db.Limit(params.Limit)
+	}
+	err = db.Order("read_at desc").Find(&result).Error
+	if err != nil || len(result) == 0 {
+		return
+	}
+	ids := make([]uint, len(result))
+	for index, item := range result {
+		ids[index] = item.BookID
+	}
+	bookMap, err := getBriefBookMap(ids)
+	if err != nil {
+		return
+	}
+	for _, item := range result {
+		item.Book = bookMap[item.BookID]
+	}
+	return
+}
+
+// MergeTrackProgress merge the anonymous reading progress of track id into account,
+// the latest read one will be kept if the book has progress in both
+func (srv *ReadingSrv) MergeTrackProgress(trackID, account string) (err error) {
+	if trackID == "" || account == "" {
+		return
+	}
+	tx := pgGetClient().Begin()
+	err = tx.Exec(`INSERT INTO reading_progresses
+		(created_at, updated_at, account, track_id, book_id, no, scroll_offset, read_at, chapter_count)
+		SELECT created_at, ?, ?, '', book_id, no, scroll_offset, read_at, chapter_count
+		FROM reading_progresses
+		WHERE account = '' AND track_id = ? AND deleted_at IS NULL
+		ON CONFLICT (account, track_id, book_id) DO UPDATE SET
+			updated_at = EXCLUDED.updated_at,
+			no = EXCLUDED.no,
+			scroll_offset = EXCLUDED.scroll_offset,
+			read_at = EXCLUDED.read_at,
+			chapter_count = EXCLUDED.chapter_count,
+			deleted_at = NULL
+		WHERE reading_progresses.read_at < EXCLUDED.read_at`,
+		util.Now(), account, trackID,
+	).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Unscoped().
+		Where("account = '' AND track_id = ?", trackID).
+		Delete(&ReadingProgress{}).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit().Error
+	return
+}
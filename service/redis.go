@@ -106,3 +106,22 @@ func (srv *RedisSrv) Set(key string, value interface{}, ttl time.Duration) (err
 	redisGetClient().Set(key, value, ttl)
 	return
 }
+
+// HIncrBy inc the field value of hash
+func (srv *RedisSrv) HIncrBy(key, field string, value int64) (err error) {
+	_, err = redisGetClient().HIncrBy(key, field, value).Result()
+	return
+}
+
+// HGetAllAndDel get all fields of hash and del
+func (srv *RedisSrv) HGetAllAndDel(key string) (result map[string]string, err error) {
+	pipe := redisGetClient().TxPipeline()
+	cmd := pipe.HGetAll(key)
+	pipe.Del(key)
+	_, err = pipe.Exec()
+	if err != nil {
+		return
+	}
+	result = cmd.Val()
+	return
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vicanso/hes"
+	"go.uber.org/zap"
+)
+
+const (
+	// 占位字符使用unicode的私有区，opencc不会对其转换
+	s2tDictPlaceholderBase = 0xE000
+	s2tDictMaxPhrases      = 0x1000
+)
+
+type (
+	// s2tDict the phrase override dictionary of s2t,
+	// the phrase is replaced by placeholder before converting
+	// and the placeholder is replaced by the override after converting
+	s2tDict struct {
+		pre  *strings.Replacer
+		post *strings.Replacer
+	}
+)
+
+var (
+	errS2TDictInvalid = hes.New("s2t dict is invalid, it should be json of phrases")
+
+	s2tDictMutex   = new(sync.RWMutex)
+	currentS2TDict *s2tDict
+	// 当前词组覆盖配置的数据，用于判断配置是否有调整
+	currentS2TDictData string
+	// 词组覆盖配置的版本，用于转换结果相关的缓存（epub、封面、ETag）
+	currentS2TDictVersion = getS2TDictVersion("")
+)
+
+// newS2TDict create the s2t dictionary from the phrases(simplified phrase: override)
+func newS2TDict(phrases map[string]string) *s2tDict {
+	keys := make([]string, 0, len(phrases))
+	for key := range phrases {
+		if key == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	// 较长的词组优先替换
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > s2tDictMaxPhrases {
+		keys = keys[:s2tDictMaxPhrases]
+	}
+	preArgs := make([]string, 0, 2*len(keys))
+	postArgs := make([]string, 0, 2*len(keys))
+	for index, key := range keys {
+		placeholder := string(rune(s2tDictPlaceholderBase + index))
+		preArgs = append(preArgs, key, placeholder)
+		postArgs = append(postArgs, placeholder, phrases[key])
+	}
+	return &s2tDict{
+		pre:  strings.NewReplacer(preArgs...),
+		post: strings.NewReplacer(postArgs...),
+	}
+}
+
+// convert convert the text by the convert function with phrase overrides
+func (dict *s2tDict) convert(text string, fn func(string) (string, error)) (string, error) {
+	if dict == nil {
+		return fn(text)
+	}
+	result, err := fn(dict.pre.Replace(text))
+	if err != nil {
+		return "", err
+	}
+	return dict.post.Replace(result), nil
+}
+
+// getS2TDict get the current s2t dictionary
+func getS2TDict() *s2tDict {
+	s2tDictMutex.RLock()
+	defer s2tDictMutex.RUnlock()
+	return currentS2TDict
+}
+
+// parseS2TDictConfigs parse the phrases of s2t dictionary configs
+func parseS2TDictConfigs(configs []*Configuration) (phrases map[string]string, data string) {
+	phrases = make(map[string]string)
+	values := make([]string, 0, len(configs))
+	// 按名称排序，保证相同词组的覆盖顺序一致
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+	for _, item := range configs {
+		m := make(map[string]string)
+		err := standardJSON.UnmarshalFromString(item.Data, &m)
+		if err != nil {
+			logger.Error("s2t dict config is invalid",
+				zap.String("name", item.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+		for key, value := range m {
+			phrases[key] = value
+		}
+		values = append(values, item.Data)
+	}
+	data = strings.Join(values, "\n")
+	return
+}
+
+// getS2TDictVersion get the version of dict data
+func getS2TDictVersion(data string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(data))
+	return fmt.Sprintf("%x", h.Sum32())
+}
+
+// GetS2TDictVersion get the version of current s2t dict,
+// it is changed when the phrase overrides are changed
+func GetS2TDictVersion() string {
+	s2tDictMutex.RLock()
+	defer s2tDictMutex.RUnlock()
+	return currentS2TDictVersion
+}
+
+// 更新简繁转换的词组覆盖配置
+func updateS2TDict(configs []*Configuration) {
+	phrases, data := parseS2TDictConfigs(configs)
+	s2tDictMutex.Lock()
+	defer s2tDictMutex.Unlock()
+	// 配置无变化则不需要更新（避免清除转换缓存）
+	if currentS2TDictData == data {
+		return
+	}
+	currentS2TDictData = data
+	currentS2TDictVersion = getS2TDictVersion(data)
+	currentS2TDict = newS2TDict(phrases)
+	// 词组覆盖已调整，之前的转换结果失效
+	convertCache.Purge()
+}
+
+// PreviewConvertLang convert simplified chinese to the lang for preview,
+// the dict data(json of phrases) is used instead of the current overrides if it is not empty
+func PreviewConvertLang(lang, text, dictData string) (string, error) {
+	if dictData == "" {
+		return ConvertLang(lang, text)
+	}
+	phrases := make(map[string]string)
+	err := standardJSON.UnmarshalFromString(dictData, &phrases)
+	if err != nil {
+		return "", errS2TDictInvalid
+	}
+	openCC := langOpenCCs[lang]
+	if openCC == nil {
+		return text, nil
+	}
+	return newS2TDict(phrases).convert(text, openCC.Convert)
+}
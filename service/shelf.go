@@ -0,0 +1,188 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/wsl/util"
+)
+
+const (
+	// 书架最多可收藏的书籍数量
+	maxShelfSize = 200
+)
+
+var (
+	errShelfFull = hes.New(fmt.Sprintf("shelf is full, it can't be more than %d books", maxShelfSize))
+)
+
+type (
+	// ShelfItem the book of user's shelf
+	ShelfItem struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		Account string `json:"account,omitempty" gorm:"type:varchar(20);not null;unique_index:idx_shelf_items_account_book"`
+		BookID  uint   `json:"bookID,omitempty" gorm:"not null;unique_index:idx_shelf_items_account_book"`
+		// 排序位置（从小到大）
+		Position int `json:"position,omitempty"`
+		// 加入书架时书籍的章节数
+		ChapterCount int `json:"chapterCount,omitempty"`
+
+		Book *Book `json:"book,omitempty" gorm:"-"`
+		// 上次阅读后新增的章节数
+		UnreadCount int `json:"unreadCount" gorm:"-"`
+	}
+	// ShelfSrv shelf service
+	ShelfSrv struct {
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&ShelfItem{})
+}
+
+// Add add book to the end of shelf, it will be ignored if the book is on shelf
+func (srv *ShelfSrv) Add(account string, bookID uint) (err error) {
+	book, err := new(BookSrv).GetPublishedByID(bookID)
+	if err != nil {
+		return
+	}
+	count := 0
+	err = pgGetClient().Model(&ShelfItem{}).Where("account = ?", account).Count(&count).Error
+	if err != nil {
+		return
+	}
+	if count >= maxShelfSize {
+		err = errShelfFull
+		return
+	}
+	now := util.Now()
+	// 加入书架时视为已阅读当前所有章节
+	err = pgGetClient().Exec(`INSERT INTO shelf_items
+		(created_at, updated_at, account, book_id, position, chapter_count)
+		SELECT ?, ?, ?, ?, COALESCE(MAX(position), 0) + 1, ?
+		FROM shelf_items WHERE account = ?
+		ON CONFLICT (account, book_id) DO NOTHING`,
+		now, now, account, bookID, book.ChapterCount, account,
+	).Error
+	return
+}
+
+// Remove remove book from shelf
+func (srv *ShelfSrv) Remove(account string, bookID uint) (err error) {
+	err = pgGetClient().Unscoped().
+		Where("account = ? AND book_id = ?", account, bookID).
+		Delete(&ShelfItem{}).Error
+	return
+}
+
+// Reorder reorder the books of shelf, the books which are not in the list
+// will be placed after them with the original order
+func (srv *ShelfSrv) Reorder(account string, bookIDs []uint) (err error) {
+	items := make([]*ShelfItem, 0)
+	err = pgGetClient().
+		Where("account = ?", account).
+		Order("position, id").
+		Find(&items).Error
+	if err != nil {
+		return
+	}
+	itemMap := make(map[uint]*ShelfItem)
+	for _, item := range items {
+		itemMap[item.BookID] = item
+	}
+	sortedItems := make([]*ShelfItem, 0, len(items))
+	for _, id := range bookIDs {
+		item := itemMap[id]
+		if item == nil {
+			continue
+		}
+		sortedItems = append(sortedItems, item)
+		delete(itemMap, id)
+	}
+	for _, item := range items {
+		if itemMap[item.BookID] != nil {
+			sortedItems = append(sortedItems, item)
+		}
+	}
+
+	tx := pgGetClient().Begin()
+	for index, item := range sortedItems {
+		position := index + 1
+		if item.Position == position {
+			continue
+		}
+		err = tx.Model(item).Update("position", position).Error
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit().Error
+	return
+}
+
+// List list the books of shelf with the unread chapter count
+func (srv *ShelfSrv) List(account string) (result []*ShelfItem, err error) {
+	result = make([]*ShelfItem, 0)
+	err = pgGetClient().
+		Where("account = ?", account).
+		Order("position, id").
+		Find(&result).Error
+	if err != nil || len(result) == 0 {
+		return
+	}
+	ids := make([]uint, len(result))
+	for index, item := range result {
+		ids[index] = item.BookID
+	}
+	bookMap, err := getBriefBookMap(ids)
+	if err != nil {
+		return
+	}
+	progresses := make([]*ReadingProgress, 0)
+	err = pgGetClient().
+		Select("book_id, chapter_count").
+		Where("account = ? AND track_id = '' AND book_id IN (?)", account, ids).
+		Find(&progresses).Error
+	if err != nil {
+		return
+	}
+	readCounts := make(map[uint]int)
+	for _, item := range progresses {
+		readCounts[item.BookID] = item.ChapterCount
+	}
+	for _, item := range result {
+		item.Book = bookMap[item.BookID]
+		if item.Book == nil {
+			continue
+		}
+		// 以加入书架与最后阅读时的章节数较大者为已读章节数
+		readCount := item.ChapterCount
+		if readCounts[item.BookID] > readCount {
+			readCount = readCounts[item.BookID]
+		}
+		if item.Book.ChapterCount > readCount {
+			item.UnreadCount = item.Book.ChapterCount - readCount
+		}
+	}
+	return
+}
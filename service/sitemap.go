@@ -0,0 +1,114 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vicanso/wsl/cs"
+)
+
+type (
+	// SitemapChapter the chapter of sitemap
+	SitemapChapter struct {
+		BookID    uint
+		NO        uint
+		UpdatedAt time.Time
+	}
+	// SitemapPage the page of chapter sitemap
+	SitemapPage struct {
+		Page    int
+		LastMod time.Time
+	}
+)
+
+// newSitemapChapterQuery the published chapters of published books
+func newSitemapChapterQuery() string {
+	return fmt.Sprintf(`FROM chapters
+		JOIN books ON books.id = chapters.book_id AND books.deleted_at IS NULL AND books.status = %d
+		WHERE chapters.deleted_at IS NULL AND chapters.status = %d`,
+		cs.PublishPublished, cs.PublishPublished,
+	)
+}
+
+// GetSitemapVersion get the version of sitemap, it is changed when the books or tags are modified
+// (the updated at of book is changed when its chapters are modified)
+func (srv *BookSrv) GetSitemapVersion() (version string, err error) {
+	result := &struct {
+		BookCount     int
+		BookUpdatedAt *time.Time
+		TagCount      int
+		TagUpdatedAt  *time.Time
+	}{}
+	err = pgGetClient().Raw(`SELECT
+		(SELECT COUNT(*) FROM books WHERE deleted_at IS NULL AND status = ?) AS book_count,
+		(SELECT MAX(updated_at) FROM books) AS book_updated_at,
+		(SELECT COUNT(*) FROM tags WHERE deleted_at IS NULL) AS tag_count,
+		(SELECT MAX(updated_at) FROM tags) AS tag_updated_at`,
+		cs.PublishPublished,
+	).Scan(result).Error
+	if err != nil {
+		return
+	}
+	var bookUpdatedAt, tagUpdatedAt int64
+	if result.BookUpdatedAt != nil {
+		bookUpdatedAt = result.BookUpdatedAt.UnixNano()
+	}
+	if result.TagUpdatedAt != nil {
+		tagUpdatedAt = result.TagUpdatedAt.UnixNano()
+	}
+	version = fmt.Sprintf("%d-%d-%d-%d", result.BookCount, bookUpdatedAt, result.TagCount, tagUpdatedAt)
+	return
+}
+
+// ListSitemapBooks list the published books(only id and updated at) for sitemap
+func (srv *BookSrv) ListSitemapBooks() (result []*Book, err error) {
+	result = make([]*Book, 0)
+	err = pgGetClient().
+		Select("id, updated_at").
+		Where("status = ?", cs.PublishPublished).
+		Order("id").
+		Find(&result).Error
+	return
+}
+
+// ListSitemapChapterPages list the pages of chapter sitemap with the last modified time
+func (srv *BookSrv) ListSitemapChapterPages(limit int) (result []*SitemapPage, err error) {
+	result = make([]*SitemapPage, 0)
+	err = pgGetClient().Raw(fmt.Sprintf(`SELECT (rn - 1) / ? AS page, MAX(updated_at) AS last_mod
+		FROM (
+			SELECT chapters.updated_at,
+			ROW_NUMBER() OVER (ORDER BY chapters.book_id, chapters.no) AS rn
+			%s
+		) t
+		GROUP BY page
+		ORDER BY page`, newSitemapChapterQuery()),
+		limit,
+	).Scan(&result).Error
+	return
+}
+
+// ListSitemapChapters list the chapters of the page for sitemap
+func (srv *BookSrv) ListSitemapChapters(page, limit int) (result []*SitemapChapter, err error) {
+	result = make([]*SitemapChapter, 0)
+	err = pgGetClient().Raw(fmt.Sprintf(`SELECT chapters.book_id, chapters.no, chapters.updated_at
+		%s
+		ORDER BY chapters.book_id, chapters.no
+		LIMIT ? OFFSET ?`, newSitemapChapterQuery()),
+		limit, page*limit,
+	).Scan(&result).Error
+	return
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/vicanso/wsl/cs"
+)
+
+type (
+	// Tag the tag(category) of book
+	Tag struct {
+		ID        uint       `gorm:"primary_key" json:"id,omitempty"`
+		CreatedAt time.Time  `json:"createdAt,omitempty"`
+		UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+		DeletedAt *time.Time `sql:"index" json:"deletedAt,omitempty"`
+
+		Name string `json:"name,omitempty" gorm:"type:varchar(20);not null;unique_index:idx_tags_name"`
+		// 用于url的标识，如/tag/:slug
+		Slug        string `json:"slug,omitempty" gorm:"type:varchar(40);not null;unique_index:idx_tags_slug"`
+		Description string `json:"description,omitempty" gorm:"type:varchar(200)"`
+
+		// 标签的书籍数量（仅查询时返回）
+		BookCount int `json:"bookCount,omitempty" gorm:"-"`
+	}
+	// TagFacet the book count of tag for the book query
+	TagFacet struct {
+		ID    uint   `json:"id,omitempty"`
+		Name  string `json:"name,omitempty"`
+		Slug  string `json:"slug,omitempty"`
+		Count int    `json:"count,omitempty"`
+	}
+	// TagSrv tag service
+	TagSrv struct {
+	}
+)
+
+func init() {
+	pgGetClient().AutoMigrate(&Tag{})
+}
+
+// normalizeTagSlugs lowercase the slugs and remove the empty or duplicate ones
+func normalizeTagSlugs(slugs []string) []string {
+	result := make([]string, 0, len(slugs))
+	exists := make(map[string]bool)
+	for _, slug := range slugs {
+		slug = strings.ToLower(strings.TrimSpace(slug))
+		if slug == "" || exists[slug] {
+			continue
+		}
+		exists[slug] = true
+		result = append(result, slug)
+	}
+	return result
+}
+
+// filterBookByTags filter the book query by tag slugs,
+// the book should have all tags if the mode is and, otherwise any of them.
+// The slugs are normalized first, so the duplicate ones do not break the count of and mode
+func filterBookByTags(db *gorm.DB, slugs []string, mode string) *gorm.DB {
+	slugs = normalizeTagSlugs(slugs)
+	if len(slugs) == 0 {
+		return db
+	}
+	if mode == cs.TagModeAnd {
+		return db.Where(`books.id IN (
+			SELECT book_tags.book_id FROM book_tags
+			JOIN tags ON tags.id = book_tags.tag_id AND tags.deleted_at IS NULL
+			WHERE tags.slug IN (?)
+			GROUP BY book_tags.book_id
+			HAVING COUNT(DISTINCT tags.id) = ?
+		)`, slugs, len(slugs))
+	}
+	return db.Where(`books.id IN (
+		SELECT book_tags.book_id FROM book_tags
+		JOIN tags ON tags.id = book_tags.tag_id AND tags.deleted_at IS NULL
+		WHERE tags.slug IN (?)
+	)`, slugs)
+}
+
+// Add add tag
+func (srv *TagSrv) Add(tag *Tag) (err error) {
+	err = pgCreate(tag)
+	return
+}
+
+// UpdateByID update tag by id
+func (srv *TagSrv) UpdateByID(id uint, data interface{}) (err error) {
+	err = pgGetClient().Model(&Tag{
+		ID: id,
+	}).Update(data).Error
+	return
+}
+
+// DeleteByID delete tag and the relations of books
+func (srv *TagSrv) DeleteByID(id uint) (err error) {
+	tx := pgGetClient().Begin()
+	err = tx.Exec(`DELETE FROM book_tags WHERE tag_id = ?`, id).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	// 直接删除，避免slug与name的唯一索引冲突
+	err = tx.Unscoped().Delete(&Tag{
+		ID: id,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit().Error
+	return
+}
+
+// GetBySlug get tag by slug
+func (srv *TagSrv) GetBySlug(slug string) (tag *Tag, err error) {
+	tag = &Tag{}
+	err = pgGetClient().Where("slug = ?", slug).First(tag).Error
+	return
+}
+
+// List list all tags with book count
+func (srv *TagSrv) List() (result []*Tag, err error) {
+	result = make([]*Tag, 0)
+	err = pgGetClient().Order("id").Find(&result).Error
+	if err != nil {
+		return
+	}
+	// book count为非数据库字段，需要单独查询
+	counts := make([]*TagFacet, 0)
+	err = pgGetClient().
+		Table("book_tags").
+		Select("book_tags.tag_id AS id, COUNT(*) AS count").
+		Joins("JOIN books ON books.id = book_tags.book_id AND books.deleted_at IS NULL AND books.status = ?", cs.PublishPublished).
+		Group("book_tags.tag_id").
+		Scan(&counts).Error
+	if err != nil {
+		return
+	}
+	countMap := make(map[uint]int)
+	for _, item := range counts {
+		countMap[item.ID] = item.Count
+	}
+	for _, item := range result {
+		item.BookCount = countMap[item.ID]
+	}
+	return
+}
+
+// ListByBook list the tags of book
+func (srv *TagSrv) ListByBook(bookID uint) (result []*Tag, err error) {
+	result = make([]*Tag, 0)
+	err = pgGetClient().
+		Joins("JOIN book_tags ON book_tags.tag_id = tags.id").
+		Where("book_tags.book_id = ?", bookID).
+		Order("tags.id").
+		Find(&result).Error
+	return
+}
+
+// SetBookTags replace the tags of book
+func (srv *TagSrv) SetBookTags(bookID uint, tagIDs []uint) (err error) {
+	tx := pgGetClient().Begin()
+	err = tx.Exec(`DELETE FROM book_tags WHERE book_id = ?`, bookID).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	if len(tagIDs) != 0 {
+		// 忽略不存在的标签
+		err = tx.Exec(`INSERT INTO book_tags (book_id, tag_id)
+			SELECT ?, id FROM tags WHERE id IN (?) AND deleted_at IS NULL`,
+			bookID, tagIDs,
+		).Error
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit().Error
+	return
+}
+
+// TagFacets count the books of each tag for the book query
+func (srv *BookSrv) TagFacets(params BookQueryParams) (result []*TagFacet, err error) {
+	result = make([]*TagFacet, 0)
+	books := newBookQuery(params).Model(&Book{}).Select("books.id").QueryExpr()
+	err = pgGetClient().
+		Table("tags").
+		Select("tags.id, tags.name, tags.slug, COUNT(*) AS count").
+		Joins("JOIN book_tags ON book_tags.tag_id = tags.id").
+		Where("tags.deleted_at IS NULL AND book_tags.book_id IN (?)", books).
+		Group("tags.id, tags.name, tags.slug").
+		Order("count desc, tags.id").
+		Scan(&result).Error
+	return
+}
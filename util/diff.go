@@ -0,0 +1,117 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "strings"
+
+const (
+	// DiffEqual the line is not changed
+	DiffEqual = "="
+	// DiffInsert the line is inserted
+	DiffInsert = "+"
+	// DiffDelete the line is deleted
+	DiffDelete = "-"
+
+	// 行数过多时不计算最长公共子序列（避免占用过多内存）
+	maxDiffCells = 4000000
+)
+
+type (
+	// DiffLine the line of diff
+	DiffLine struct {
+		Type string `json:"type,omitempty"`
+		Text string `json:"text"`
+	}
+)
+
+// DiffLines get the line-level diff of text, it's based on the longest common subsequence
+func DiffLines(a, b string) []*DiffLine {
+	aLines := strings.Split(a, "\n")
+	bLines := strings.Split(b, "\n")
+	// 去除相同的前缀与后缀，减少计算量
+	prefix := 0
+	for prefix < len(aLines) && prefix < len(bLines) && aLines[prefix] == bLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(aLines)-prefix &&
+		suffix < len(bLines)-prefix &&
+		aLines[len(aLines)-1-suffix] == bLines[len(bLines)-1-suffix] {
+		suffix++
+	}
+
+	result := make([]*DiffLine, 0, len(aLines)+len(bLines))
+	for _, line := range aLines[:prefix] {
+		result = append(result, &DiffLine{DiffEqual, line})
+	}
+	result = append(result, diffLCS(aLines[prefix:len(aLines)-suffix], bLines[prefix:len(bLines)-suffix])...)
+	for _, line := range aLines[len(aLines)-suffix:] {
+		result = append(result, &DiffLine{DiffEqual, line})
+	}
+	return result
+}
+
+func diffLCS(a, b []string) []*DiffLine {
+	n := len(a)
+	m := len(b)
+	result := make([]*DiffLine, 0, n+m)
+	if (n+1)*(m+1) > maxDiffCells {
+		for _, line := range a {
+			result = append(result, &DiffLine{DiffDelete, line})
+		}
+		for _, line := range b {
+			result = append(result, &DiffLine{DiffInsert, line})
+		}
+		return result
+	}
+	// lcs[i][j] 为a[i:]与b[j:]的最长公共子序列长度
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, &DiffLine{DiffEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, &DiffLine{DiffDelete, a[i]})
+			i++
+		default:
+			result = append(result, &DiffLine{DiffInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		result = append(result, &DiffLine{DiffDelete, a[i]})
+	}
+	for ; j < m; j++ {
+		result = append(result, &DiffLine{DiffInsert, b[j]})
+	}
+	return result
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "regexp"
+
+var (
+	botUserAgentReg = regexp.MustCompile(`(?i)(bot|spider|crawl|slurp|curl|wget|python-|go-http-client|java/|headless|facebookexternalhit|bingpreview)`)
+)
+
+// IsBot check the user agent is bot(crawler, spider or script)
+func IsBot(userAgent string) bool {
+	if userAgent == "" {
+		return true
+	}
+	return botUserAgentReg.MatchString(userAgent)
+}
@@ -15,20 +15,147 @@
 package validate
 
 import (
+	"regexp"
+
 	"github.com/asaskevich/govalidator"
+	"github.com/vicanso/wsl/cs"
+)
+
+var (
+	bookArchiveNameReg = regexp.MustCompile(`(?i)\.(zip|tar\.gz|tgz)$`)
 )
 
 func init() {
+	// 书籍关键字（简体或繁体）
 	Add("xBookKeyword", func(i interface{}, _ interface{}) bool {
-		return checkStringLength(i, 1, 20)
+		return checkRuneLength(i, 1, 20)
+	})
+
+	// opds的书籍排序(热门与最近更新)
+	Add("xOPDSSort", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return govalidator.IsIn(value, "-hot_score", "-updated_at")
+	})
+
+	Add("xBookSearchKeyword", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 60)
+	})
+
+	Add("xBookArchiveName", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return bookArchiveNameReg.MatchString(value)
+	})
+
+	Add("xBookSyncDryRun", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return govalidator.IsIn(value, "true", "false")
+	})
+
+	Add("xBookSyncJobStatus", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, cs.BookSyncJobRunning, cs.BookSyncJobCanceled)
+	})
+
+	Add("xBookID", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(uint)
+		if !ok {
+			return false
+		}
+		return value > 0
+	})
+
+	Add("xBookIDs", func(i interface{}, _ interface{}) bool {
+		values, ok := i.([]uint)
+		if !ok || len(values) == 0 || len(values) > 200 {
+			return false
+		}
+		for _, value := range values {
+			if value == 0 {
+				return false
+			}
+		}
+		return true
 	})
 
+	Add("xBookChapterNO", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, 0, 99999)
+	})
+
+	Add("xReadingScrollOffset", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, 0, 10000000)
+	})
+
+	Add("xBookReviewScore", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, 1, 5)
+	})
+
+	Add("xBookReviewContent", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 2000)
+	})
+
+	Add("xChapterTitle", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 300)
+	})
+
+	Add("xChapterContent", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 1024*1024)
+	})
+
+	Add("xPublishStatus", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, cs.PublishDraft, cs.PublishPublished)
+	})
+
+	Add("xChapterNote", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 200)
+	})
+
+	Add("xChapterRevisionID", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(uint)
+		if !ok {
+			return false
+		}
+		return value > 0
+	})
+
+	// 人工设置的热度，0表示取消
 	Add("xBookHot", func(i interface{}, _ interface{}) bool {
+		// 更新时使用指针，以区分未设置与设置为0
+		if p, ok := i.(*int); ok && p != nil {
+			i = *p
+		}
 		value, ok := i.(int)
 		if !ok {
 			return false
 		}
-		return govalidator.InRangeInt(value, 1, 100)
+		return govalidator.InRangeInt(value, 0, 100)
 	})
 
 	Add("xBookSummary", func(i interface{}, _ interface{}) bool {
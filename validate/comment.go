@@ -0,0 +1,44 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"github.com/asaskevich/govalidator"
+
+	"github.com/vicanso/wsl/cs"
+)
+
+func init() {
+	Add("xCommentID", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(uint)
+		if !ok {
+			return false
+		}
+		return value > 0
+	})
+	Add("xCommentContent", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 1000)
+	})
+	Add("xCommentReportReason", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 200)
+	})
+	Add("xCommentStatus", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, cs.CommentPending, cs.CommentHidden)
+	})
+}
@@ -36,6 +36,13 @@ func init() {
 	Add("xFields", func(i interface{}, _ interface{}) bool {
 		return checkASCIIStringLength(i, 1, 50)
 	})
+	Add("xPage", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(int)
+		if !ok {
+			return false
+		}
+		return govalidator.InRangeInt(value, 1, 1000)
+	})
 	Add("xSort", func(i interface{}, _ interface{}) bool {
 		return checkASCIIStringLength(i, 1, 50)
 	})
@@ -15,6 +15,7 @@
 package validate
 
 import (
+	"github.com/asaskevich/govalidator"
 	"github.com/vicanso/wsl/cs"
 )
 
@@ -29,6 +30,18 @@ func init() {
 	Add("xConfigData", func(i interface{}, _ interface{}) bool {
 		return checkStringLength(i, 1, 500)
 	})
+	// 简繁转换预览的文本
+	Add("xConfigS2TText", func(i interface{}, _ interface{}) bool {
+		return checkStringLength(i, 1, 5000)
+	})
+	// 繁体语言（包括地区变体）
+	Add("xTraditionalLang", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return govalidator.IsIn(value, cs.LangTC, cs.LangTW, cs.LangHK)
+	})
 	Add("xConfigNames", func(i interface{}, _ interface{}) bool {
 		return checkAlphanumericStringLength(i, 2, 100)
 	})
@@ -0,0 +1,91 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asaskevich/govalidator"
+
+	"github.com/vicanso/wsl/cs"
+)
+
+var (
+	tagSlugReg = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+)
+
+func init() {
+	Add("xTagName", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		count := utf8.RuneCountInString(value)
+		return count >= 1 && count <= 20
+	})
+	Add("xTagSlug", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return tagSlugReg.MatchString(value)
+	})
+	// 以,分隔的slug列表（忽略大小写与空格，查询时再统一处理）
+	Add("xTagSlugs", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		slugs := strings.Split(value, ",")
+		if len(slugs) > 10 {
+			return false
+		}
+		for _, slug := range slugs {
+			slug = strings.ToLower(strings.TrimSpace(slug))
+			if !tagSlugReg.MatchString(slug) {
+				return false
+			}
+		}
+		return true
+	})
+	Add("xTagMode", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return govalidator.IsIn(value, cs.TagModeAnd, cs.TagModeOr)
+	})
+	Add("xTagDescription", func(i interface{}, _ interface{}) bool {
+		value, ok := i.(string)
+		if !ok {
+			return false
+		}
+		return utf8.RuneCountInString(value) <= 200
+	})
+	Add("xTagIDs", func(i interface{}, _ interface{}) bool {
+		values, ok := i.([]uint)
+		if !ok || len(values) > 20 {
+			return false
+		}
+		for _, value := range values {
+			if value == 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -17,6 +17,7 @@ package validate
 import (
 	"encoding/json"
 	"regexp"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
 	jsoniter "github.com/json-iterator/go"
@@ -133,6 +134,19 @@ func checkAlphanumericStringLength(i interface{}, min, max int) bool {
 	return true
 }
 
+// checkRuneLength check the length of string by runes(chinese is one rune)
+func checkRuneLength(i interface{}, min, max int) bool {
+	value, ok := i.(string)
+	if !ok {
+		return false
+	}
+	size := utf8.RuneCountInString(value)
+	if size < min || size > max {
+		return false
+	}
+	return true
+}
+
 func checkStringLength(i interface{}, min, max int) bool {
 	value, ok := i.(string)
 	if !ok {
@@ -372,7 +372,7 @@ class Home extends React.Component {
               sort: ""
             };
             if (key === hotKey) {
-              data.sort = "-hot";
+              data.sort = "-hot_score";
             }
             this.reset(() => {
               this.goTo(data, true);